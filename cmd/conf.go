@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"runtime"
+	"time"
+
 	"github.com/rendau/dop/dopTools"
 	"github.com/spf13/viper"
+
+	"github.com/rendau/kazan/internal/domain/types"
 )
 
 var conf = struct {
@@ -10,13 +15,129 @@ var conf = struct {
 	LogLevel     string `mapstructure:"LOG_LEVEL"`
 	HttpListen   string `mapstructure:"HTTP_LISTEN"`
 	HttpCors     bool   `mapstructure:"HTTP_CORS"`
+	AuthToken    string `mapstructure:"AUTH_TOKEN"`
 	SwagHost     string `mapstructure:"SWAG_HOST"`
 	SwagBasePath string `mapstructure:"SWAG_BASE_PATH"`
 	SwagSchema   string `mapstructure:"SWAG_SCHEMA"`
+	StorageType  string `mapstructure:"STORAGE_TYPE"`
+	DirPath      string `mapstructure:"DIR_PATH"`
+	S3Endpoint   string `mapstructure:"S3_ENDPOINT"`
+	S3AccessKey  string `mapstructure:"S3_ACCESS_KEY"`
+	S3SecretKey  string `mapstructure:"S3_SECRET_KEY"`
+	S3Region     string `mapstructure:"S3_REGION"`
+	S3Bucket     string `mapstructure:"S3_BUCKET"`
+	S3Prefix     string `mapstructure:"S3_PREFIX"`
+	S3UseSsl     bool   `mapstructure:"S3_USE_SSL"`
 	ImgMaxWidth  int    `mapstructure:"IMG_MAX_WIDTH"`
 	ImgMaxHeight int    `mapstructure:"IMG_MAX_HEIGHT"`
+
+	ImgQuality        int    `mapstructure:"IMG_QUALITY"`
+	ImgQualityMin     int    `mapstructure:"IMG_QUALITY_MIN"`
+	ImgQualityMax     int    `mapstructure:"IMG_QUALITY_MAX"`
+	ImgPngCompression string `mapstructure:"IMG_PNG_COMPRESSION"`
+
+	ImgPresets map[string]imgPresetSt `mapstructure:"IMG_PRESETS"`
+	ImgStrict  bool                   `mapstructure:"IMG_STRICT"`
+
+	ImgStripMeta bool `mapstructure:"IMG_STRIP_META"`
+
+	ImgWMarkPath     string   `mapstructure:"IMG_WMARK_PATH"`
+	ImgWMarkPosition string   `mapstructure:"IMG_WMARK_POSITION"`
+	ImgWMarkOpacity  float64  `mapstructure:"IMG_WMARK_OPACITY"`
+	ImgWMarkScale    float64  `mapstructure:"IMG_WMARK_SCALE"`
+	ImgWMarkMargin   int      `mapstructure:"IMG_WMARK_MARGIN"`
+	ImgWMarkDirs     []string `mapstructure:"IMG_WMARK_DIRS"`
+	ImgWMarkOnDemand bool     `mapstructure:"IMG_WMARK_ON_DEMAND"`
+
+	ImgMaxPixels     int64 `mapstructure:"IMG_MAX_PIXELS"`
+	ImgMaxFrames     int   `mapstructure:"IMG_MAX_FRAMES"`
+	ImgMaxAnimPixels int64 `mapstructure:"IMG_MAX_ANIM_PIXELS"`
+	ImgMaxOutWidth   int   `mapstructure:"IMG_MAX_OUT_WIDTH"`
+	ImgMaxOutHeight  int   `mapstructure:"IMG_MAX_OUT_HEIGHT"`
+
+	ImgDecodeLimit int           `mapstructure:"IMG_DECODE_LIMIT"`
+	ImgDecodeWait  time.Duration `mapstructure:"IMG_DECODE_WAIT"`
+
+	UrlSignSecret string        `mapstructure:"URL_SIGN_SECRET"`
+	UrlSignTtl    time.Duration `mapstructure:"URL_SIGN_TTL"`
+	PublicUrl     string        `mapstructure:"PUBLIC_URL"`
+
+	CacheRawSize       int64 `mapstructure:"CACHE_RAW_SIZE"`
+	CacheImgSize       int64 `mapstructure:"CACHE_IMG_SIZE"`
+	CacheMaxObjectSize int64 `mapstructure:"CACHE_MAX_OBJECT_SIZE"`
+	CacheDiskSize      int64 `mapstructure:"CACHE_DISK_SIZE"`
+
+	ZipCompressionLevel int  `mapstructure:"ZIP_COMPRESSION_LEVEL"`
+	ZipStoreCompressed  bool `mapstructure:"ZIP_STORE_COMPRESSED"`
+
+	Dedup bool `mapstructure:"DEDUP"`
+
+	UploadExpiration time.Duration `mapstructure:"UPLOAD_EXPIRATION"`
+	UploadMaxSize    int64         `mapstructure:"UPLOAD_MAX_SIZE"`
 }{}
 
+// imgPresetSt is an image preset in conf.yml, keys are the same as query parameters of image request
+type imgPresetSt struct {
+	W          int     `mapstructure:"w"`
+	H          int     `mapstructure:"h"`
+	M          string  `mapstructure:"m"`
+	Blur       float64 `mapstructure:"blur"`
+	Grayscale  bool    `mapstructure:"grayscale"`
+	G          string  `mapstructure:"g"`
+	Cx         int     `mapstructure:"cx"`
+	Cy         int     `mapstructure:"cy"`
+	Cw         int     `mapstructure:"cw"`
+	Ch         int     `mapstructure:"ch"`
+	Rot        float64 `mapstructure:"rot"`
+	Bg         string  `mapstructure:"bg"`
+	FlipH      bool    `mapstructure:"flip_h"`
+	FlipV      bool    `mapstructure:"flip_v"`
+	Sharpen    float64 `mapstructure:"sharpen"`
+	Brightness float64 `mapstructure:"brightness"`
+	Contrast   float64 `mapstructure:"contrast"`
+	Gamma      float64 `mapstructure:"gamma"`
+	Saturation float64 `mapstructure:"saturation"`
+	Invert     bool    `mapstructure:"invert"`
+	Wmark      bool    `mapstructure:"wmark"`
+	F          string  `mapstructure:"f"`
+	Frame      *int    `mapstructure:"frame"`
+	Q          int     `mapstructure:"q"`
+	PngC       string  `mapstructure:"png_c"`
+}
+
+func (o imgPresetSt) toImgPars() *types.ImgParsSt {
+	return &types.ImgParsSt{
+		Method:    o.M,
+		Width:     o.W,
+		Height:    o.H,
+		Blur:      o.Blur,
+		Grayscale: o.Grayscale,
+		WMark:     o.Wmark,
+		Gravity:   o.G,
+		Format:    o.F,
+		Frame:     o.Frame,
+
+		CropX: o.Cx,
+		CropY: o.Cy,
+		CropW: o.Cw,
+		CropH: o.Ch,
+
+		Rotate:     o.Rot,
+		Background: o.Bg,
+		FlipH:      o.FlipH,
+		FlipV:      o.FlipV,
+		Sharpen:    o.Sharpen,
+		Brightness: o.Brightness,
+		Contrast:   o.Contrast,
+		Gamma:      o.Gamma,
+		Saturation: o.Saturation,
+		Invert:     o.Invert,
+
+		Quality:        o.Q,
+		PngCompression: o.PngC,
+	}
+}
+
 func confLoad() {
 	dopTools.SetViperDefaultsFromObj(conf)
 
@@ -26,6 +147,31 @@ func confLoad() {
 	viper.SetDefault("SWAG_HOST", "example.com")
 	viper.SetDefault("SWAG_BASE_PATH", "/")
 	viper.SetDefault("SWAG_SCHEMA", "https")
+	viper.SetDefault("STORAGE_TYPE", "fs")
+	viper.SetDefault("DIR_PATH", "/data")
+	viper.SetDefault("S3_USE_SSL", "true")
+	viper.SetDefault("IMG_QUALITY", "90")
+	viper.SetDefault("IMG_QUALITY_MIN", "10")
+	viper.SetDefault("IMG_QUALITY_MAX", "100")
+	viper.SetDefault("IMG_PNG_COMPRESSION", "default")
+	viper.SetDefault("IMG_PRESETS", map[string]any{})
+	viper.SetDefault("IMG_WMARK_POSITION", "bottom_right")
+	viper.SetDefault("IMG_WMARK_OPACITY", "0.5")
+	viper.SetDefault("IMG_WMARK_SCALE", "0.2")
+	viper.SetDefault("IMG_WMARK_MARGIN", "10")
+	viper.SetDefault("IMG_MAX_PIXELS", "50000000")
+	viper.SetDefault("IMG_MAX_FRAMES", "500")
+	viper.SetDefault("IMG_MAX_ANIM_PIXELS", "200000000")
+	viper.SetDefault("IMG_MAX_OUT_WIDTH", "8192")
+	viper.SetDefault("IMG_MAX_OUT_HEIGHT", "8192")
+	viper.SetDefault("IMG_DECODE_LIMIT", runtime.NumCPU())
+	viper.SetDefault("IMG_DECODE_WAIT", "5s")
+	viper.SetDefault("CACHE_RAW_SIZE", "67108864")       // 64 MiB
+	viper.SetDefault("CACHE_IMG_SIZE", "268435456")      // 256 MiB
+	viper.SetDefault("CACHE_MAX_OBJECT_SIZE", "8388608") // 8 MiB
+	viper.SetDefault("ZIP_COMPRESSION_LEVEL", "-1")
+	viper.SetDefault("ZIP_STORE_COMPRESSED", "true")
+	viper.SetDefault("UPLOAD_EXPIRATION", "24h")
 
 	viper.SetConfigFile("conf.yml")
 	_ = viper.ReadInConfig()
@@ -1,23 +1,30 @@
 package cmd
 
 import (
+	"image"
 	"os"
 	"time"
 
+	"github.com/disintegration/imaging"
 	dopLoggerZap "github.com/rendau/dop/adapters/logger/zap"
 	dopServerHttps "github.com/rendau/dop/adapters/server/https"
 	"github.com/rendau/dop/dopTools"
 
 	"github.com/rendau/kazan/docs"
 	"github.com/rendau/kazan/internal/adapters/server/rest"
+	"github.com/rendau/kazan/internal/adapters/storage"
+	storageFs "github.com/rendau/kazan/internal/adapters/storage/fs"
+	storageS3 "github.com/rendau/kazan/internal/adapters/storage/s3"
 	"github.com/rendau/kazan/internal/domain/core"
+	"github.com/rendau/kazan/internal/domain/types"
 )
 
 func Execute() {
-	// var err error
+	var err error
 
 	app := struct {
 		lg         *dopLoggerZap.St
+		storage    storage.Storage
 		core       *core.St
 		restApi    *rest.St
 		restApiSrv *dopServerHttps.St
@@ -27,13 +34,104 @@ func Execute() {
 
 	app.lg = dopLoggerZap.New(conf.LogLevel, conf.Debug)
 
+	switch conf.StorageType {
+	case "fs":
+		app.storage = storageFs.New(app.lg, conf.DirPath)
+	case "s3":
+		var s3Storage *storageS3.St
+
+		s3Storage, err = storageS3.New(app.lg, storageS3.OptionsSt{
+			Endpoint:  conf.S3Endpoint,
+			AccessKey: conf.S3AccessKey,
+			SecretKey: conf.S3SecretKey,
+			Region:    conf.S3Region,
+			Bucket:    conf.S3Bucket,
+			Prefix:    conf.S3Prefix,
+			UseSsl:    conf.S3UseSsl,
+		})
+		if err != nil {
+			app.lg.Fatal(err)
+		}
+
+		err = s3Storage.EnsureBucket()
+		if err != nil {
+			app.lg.Fatal(err)
+		}
+
+		app.storage = s3Storage
+	default:
+		app.lg.Fatal("Unknown storage type: " + conf.StorageType)
+	}
+
+	imgPresets := make(map[string]*types.ImgParsSt, len(conf.ImgPresets))
+	for name, preset := range conf.ImgPresets {
+		imgPresets[name] = preset.toImgPars()
+	}
+
+	var imgWMark image.Image
+	if conf.ImgWMarkPath != "" {
+		imgWMark, err = imaging.Open(conf.ImgWMarkPath)
+		if err != nil {
+			app.lg.Fatalw("Fail to open watermark image", err, "path", conf.ImgWMarkPath)
+		}
+	}
+
 	app.core = core.New(
 		app.lg,
+		app.storage,
 		conf.ImgMaxWidth,
 		conf.ImgMaxHeight,
+		core.ImgOptionsSt{
+			Quality:        conf.ImgQuality,
+			QualityMin:     conf.ImgQualityMin,
+			QualityMax:     conf.ImgQualityMax,
+			PngCompression: conf.ImgPngCompression,
+			Presets:        imgPresets,
+			Strict:         conf.ImgStrict,
+			StripMeta:      conf.ImgStripMeta,
+			WMark:          imgWMark,
+			WMarkPosition:  conf.ImgWMarkPosition,
+			WMarkOpacity:   conf.ImgWMarkOpacity,
+			WMarkScale:     conf.ImgWMarkScale,
+			WMarkMargin:    conf.ImgWMarkMargin,
+			WMarkDirPaths:  conf.ImgWMarkDirs,
+			WMarkOnDemand:  conf.ImgWMarkOnDemand,
+			MaxPixels:      conf.ImgMaxPixels,
+			MaxFrames:      conf.ImgMaxFrames,
+			MaxAnimPixels:  conf.ImgMaxAnimPixels,
+			MaxOutWidth:    conf.ImgMaxOutWidth,
+			MaxOutHeight:   conf.ImgMaxOutHeight,
+			DecodeLimit:    conf.ImgDecodeLimit,
+			DecodeWait:     conf.ImgDecodeWait,
+		},
+		core.CacheOptionsSt{
+			RawSize:       conf.CacheRawSize,
+			ImgSize:       conf.CacheImgSize,
+			MaxObjectSize: conf.CacheMaxObjectSize,
+			DiskSize:      conf.CacheDiskSize,
+		},
+		core.ZipOptionsSt{
+			CompressionLevel: conf.ZipCompressionLevel,
+			StoreCompressed:  conf.ZipStoreCompressed,
+		},
+		core.UploadOptionsSt{
+			Dedup:      conf.Dedup,
+			Expiration: conf.UploadExpiration,
+			MaxSize:    conf.UploadMaxSize,
+		},
 		false,
 	)
 
+	err = app.core.Img.ValidatePresets()
+	if err != nil {
+		app.lg.Fatal(err)
+	}
+
+	err = app.core.Img.ValidateWMark()
+	if err != nil {
+		app.lg.Fatal(err)
+	}
+
 	docs.SwaggerInfo.Host = conf.SwagHost
 	docs.SwaggerInfo.BasePath = conf.SwagBasePath
 	docs.SwaggerInfo.Schemes = []string{conf.SwagSchema}
@@ -51,6 +149,10 @@ func Execute() {
 			app.lg,
 			app.core,
 			conf.HttpCors,
+			conf.AuthToken,
+			conf.UrlSignSecret,
+			conf.UrlSignTtl,
+			conf.PublicUrl,
 		),
 		app.lg,
 	)
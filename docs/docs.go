@@ -16,6 +16,169 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/cache/stats": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cache"
+                ],
+                "summary": "Get counters of cache pools.",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/types.CacheStatsSt"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    }
+                }
+            }
+        },
+        "/dir/:path": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dir"
+                ],
+                "summary": "List directory contents.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "path",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "name": "cols",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "only_count",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "page_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "sort_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "with_total_count",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/dopTypes.PaginatedListRep"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "results": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/types.DirEntrySt"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    }
+                }
+            }
+        },
+        "/info/:path": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "static"
+                ],
+                "summary": "Get properties of image: dimensions, format, EXIF, dominant color.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "path",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ImgInfoSt"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    }
+                }
+            }
+        },
         "/kvs/:key": {
             "get": {
                 "produces": [
@@ -105,6 +268,86 @@ const docTemplate = `{
                 }
             }
         },
+        "/meta/:path": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "static"
+                ],
+                "summary": "Get metadata of file or ZIP directory.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "path",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.StaticMetaSt"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    }
+                }
+            }
+        },
+        "/srcset/:path": {
+            "get": {
+                "description": "Urls are signed if signing is configured, then auth token is required.\nSizes attribute depends on the page layout, so it is left to the client.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "static"
+                ],
+                "summary": "Get srcset of image for preset (pixel ratios) or list of widths.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "path",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "name": "preset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "comma separated",
+                        "name": "widths",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/rest.SrcsetRepSt"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    }
+                }
+            }
+        },
         "/static": {
             "post": {
                 "consumes": [
@@ -136,12 +379,19 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/dopTypes.ErrRep"
                         }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
                     }
                 }
             }
         },
         "/static/:path": {
             "get": {
+                "description": "With placeholder=true returns JSON types.ImgPlaceholderSt instead of the file, it is not given for watermark directories.",
                 "produces": [
                     "application/octet-stream"
                 ],
@@ -157,16 +407,111 @@ const docTemplate = `{
                         "in": "path",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "name": "bg",
+                        "in": "query"
+                    },
                     {
                         "type": "number",
                         "name": "blur",
                         "in": "query"
                     },
+                    {
+                        "type": "number",
+                        "name": "brightness",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "ch",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "contrast",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "cw",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "cx",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "cy",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
                         "name": "download",
                         "in": "query"
                     },
+                    {
+                        "type": "number",
+                        "name": "dpr",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "exp",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "jpeg",
+                            "png",
+                            "webp",
+                            "avif",
+                            "gif",
+                            "auto"
+                        ],
+                        "type": "string",
+                        "name": "f",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "flip_h",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "flip_v",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "frame",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "center",
+                            "top",
+                            "bottom",
+                            "left",
+                            "right",
+                            "top_left",
+                            "top_right",
+                            "bottom_left",
+                            "bottom_right",
+                            "smart"
+                        ],
+                        "type": "string",
+                        "name": "g",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "gamma",
+                        "in": "query"
+                    },
                     {
                         "type": "boolean",
                         "name": "grayscale",
@@ -178,20 +523,505 @@ const docTemplate = `{
                         "in": "query"
                     },
                     {
+                        "type": "boolean",
+                        "name": "invert",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "fill",
+                            "fit",
+                            "crop"
+                        ],
                         "type": "string",
                         "name": "m",
                         "in": "query"
                     },
                     {
-                        "type": "integer",
-                        "name": "w",
+                        "type": "boolean",
+                        "name": "placeholder",
                         "in": "query"
-                    }
-                ],
-                "responses": {
-                    "200": {
+                    },
+                    {
+                        "enum": [
+                            "default",
+                            "no",
+                            "speed",
+                            "best"
+                        ],
+                        "type": "string",
+                        "name": "png_c",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "preset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "rot",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "saturation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "sharpen",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "sig",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "w",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "wmark",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
                         "description": "OK"
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "static"
+                ],
+                "summary": "Remove file or ZIP directory.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "path",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    }
+                }
+            },
+            "head": {
+                "description": "With placeholder=true returns JSON types.ImgPlaceholderSt instead of the file, it is not given for watermark directories.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "static"
+                ],
+                "summary": "Get or download file.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "path",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "name": "bg",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "blur",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "brightness",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "ch",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "contrast",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "cw",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "cx",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "cy",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "download",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "dpr",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "exp",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "jpeg",
+                            "png",
+                            "webp",
+                            "avif",
+                            "gif",
+                            "auto"
+                        ],
+                        "type": "string",
+                        "name": "f",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "flip_h",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "flip_v",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "frame",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "center",
+                            "top",
+                            "bottom",
+                            "left",
+                            "right",
+                            "top_left",
+                            "top_right",
+                            "bottom_left",
+                            "bottom_right",
+                            "smart"
+                        ],
+                        "type": "string",
+                        "name": "g",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "gamma",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "grayscale",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "h",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "invert",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "fill",
+                            "fit",
+                            "crop"
+                        ],
+                        "type": "string",
+                        "name": "m",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "placeholder",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "default",
+                            "no",
+                            "speed",
+                            "best"
+                        ],
+                        "type": "string",
+                        "name": "png_c",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "preset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "rot",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "saturation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "name": "sharpen",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "sig",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "w",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "name": "wmark",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload": {
+            "post": {
+                "description": "Upload-Metadata keys: filename, dir, no_cut, extract_zip, strip_meta, tags (comma separated).\nResult path of completed upload is returned in X-File-Path header.",
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Create resumable upload.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "1.0.0",
+                        "name": "Tus-Resumable",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "length",
+                        "name": "Upload-Length",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "metadata",
+                        "name": "Upload-Metadata",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dopTypes.ErrRep"
+                        }
+                    }
+                }
+            },
+            "options": {
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Get tus server capabilities.",
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/upload/:id": {
+            "delete": {
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Terminate resumable upload.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "1.0.0",
+                        "name": "Tus-Resumable",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found"
+                    }
+                }
+            },
+            "head": {
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Get offset of resumable upload.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "1.0.0",
+                        "name": "Tus-Resumable",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "404": {
+                        "description": "Not Found"
+                    }
+                }
+            },
+            "patch": {
+                "consumes": [
+                    "application/offset+octet-stream"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Upload next chunk of resumable upload.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "1.0.0",
+                        "name": "Tus-Resumable",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "offset",
+                        "name": "Upload-Offset",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
@@ -220,9 +1050,30 @@ const docTemplate = `{
                 }
             }
         },
+        "dopTypes.PaginatedListRep": {
+            "type": "object",
+            "properties": {
+                "page": {
+                    "type": "integer"
+                },
+                "page_size": {
+                    "type": "integer"
+                },
+                "results": {},
+                "total_count": {
+                    "type": "integer"
+                }
+            }
+        },
         "rest.SaveRepSt": {
             "type": "object",
             "properties": {
+                "blur_hash": {
+                    "type": "string"
+                },
+                "lqip": {
+                    "type": "string"
+                },
                 "path": {
                     "type": "string"
                 }
@@ -246,6 +1097,162 @@ const docTemplate = `{
                 },
                 "no_cut": {
                     "type": "boolean"
+                },
+                "placeholder": {
+                    "type": "boolean"
+                },
+                "strip_meta": {
+                    "type": "boolean"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "rest.SrcsetRepSt": {
+            "type": "object",
+            "properties": {
+                "src": {
+                    "type": "string"
+                },
+                "srcset": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.CacheStatsSt": {
+            "type": "object",
+            "properties": {
+                "budget": {
+                    "type": "integer"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "evictions": {
+                    "type": "integer"
+                },
+                "hits": {
+                    "type": "integer"
+                },
+                "misses": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "types.DirEntrySt": {
+            "type": "object",
+            "properties": {
+                "content_type": {
+                    "type": "string"
+                },
+                "is_dir": {
+                    "type": "boolean"
+                },
+                "is_zip_dir": {
+                    "type": "boolean"
+                },
+                "mod_time": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "types.ImgExifSt": {
+            "type": "object",
+            "properties": {
+                "captured_at": {
+                    "type": "string"
+                },
+                "lat": {
+                    "type": "number"
+                },
+                "lon": {
+                    "type": "number"
+                },
+                "make": {
+                    "type": "string"
+                },
+                "model": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.ImgInfoSt": {
+            "type": "object",
+            "properties": {
+                "color_model": {
+                    "type": "string"
+                },
+                "dominant_color": {
+                    "description": "hex rrggbb",
+                    "type": "string"
+                },
+                "exif": {
+                    "$ref": "#/definitions/types.ImgExifSt"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "frames": {
+                    "type": "integer"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "width": {
+                    "description": "Width and Height are given after EXIF orientation is applied",
+                    "type": "integer"
+                }
+            }
+        },
+        "types.StaticMetaSt": {
+            "type": "object",
+            "properties": {
+                "blur_hash": {
+                    "description": "placeholder of image, computed on request",
+                    "type": "string"
+                },
+                "content_type": {
+                    "type": "string"
+                },
+                "lqip": {
+                    "type": "string"
+                },
+                "original_name": {
+                    "type": "string"
+                },
+                "sha256": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "uploaded_at": {
+                    "type": "string"
                 }
             }
         }
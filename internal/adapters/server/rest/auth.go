@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dopHttps "github.com/rendau/dop/adapters/server/https"
+	"github.com/rendau/dop/dopErrs"
+	"github.com/rendau/dop/dopTypes"
+
+	"github.com/rendau/kazan/internal/domain/errs"
+	"github.com/rendau/kazan/pkg/urlsign"
+)
+
+// checkAuth compares request token with configured one, requests are denied if token is not configured
+func (a *St) checkAuth(c *gin.Context) bool {
+	token := dopHttps.GetAuthToken(c)
+
+	if a.authToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.authToken)) != 1 {
+		dopHttps.Error(c, dopErrs.NotAuthorized)
+		return false
+	}
+
+	return true
+}
+
+// checkSignature verifies signature of url if signing is configured.
+// Only requests with transformation or download parameters have to be signed.
+func (a *St) checkSignature(c *gin.Context, urlPath string, pars *GetParamsSt) bool {
+	if a.urlSignSecret == "" || *pars == (GetParamsSt{}) {
+		return true
+	}
+
+	err := urlsign.Verify(a.urlSignSecret, urlPath, c.Request.URL.Query(), time.Now())
+	if err != nil {
+		errCode := errs.BadSignature
+		if err == urlsign.ErrExpired {
+			errCode = errs.SignatureExpired
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, dopTypes.ErrRep{ErrorCode: errCode.Error()})
+		return false
+	}
+
+	return true
+}
+
+// staticUrl returns url of the file, it is signed if signing is configured
+func (a *St) staticUrl(filePath string, query url.Values) string {
+	if a.urlSignSecret != "" {
+		var expires time.Time
+		if a.urlSignTtl > 0 {
+			expires = time.Now().Add(a.urlSignTtl)
+		}
+
+		return urlsign.Url(a.urlSignSecret, a.publicUrl, filePath, query, expires)
+	}
+
+	u := &url.URL{Path: "/static/" + strings.Trim(path.Clean("/"+filePath), "/")}
+
+	return strings.TrimRight(a.publicUrl, "/") + u.EscapedPath() + "?" + query.Encode()
+}
@@ -0,0 +1,21 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Router  /cache/stats [get]
+// @Tags    cache
+// @Summary Get counters of cache pools.
+// @Produce json
+// @Success 200 {object} map[string]types.CacheStatsSt
+// @Failure 400 {object} dopTypes.ErrRep
+func (a *St) hCacheStats(c *gin.Context) {
+	if !a.checkAuth(c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, a.core.Cache.Stats())
+}
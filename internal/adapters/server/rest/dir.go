@@ -0,0 +1,59 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	dopHttps "github.com/rendau/dop/adapters/server/https"
+	"github.com/rendau/dop/dopErrs"
+	"github.com/rendau/dop/dopTools"
+	"github.com/rendau/dop/dopTypes"
+
+	"github.com/rendau/kazan/internal/domain/types"
+)
+
+// @Router  /dir/:path [get]
+// @Tags    dir
+// @Summary List directory contents.
+// @Param   path  path  string          true  "path"
+// @Param   query query DirListParamsSt false "query"
+// @Produce json
+// @Success 200 {object} dopTypes.PaginatedListRep{results=[]types.DirEntrySt}
+// @Failure 400 {object} dopTypes.ErrRep
+func (a *St) hDirList(c *gin.Context) {
+	if !a.checkAuth(c) {
+		return
+	}
+
+	urlPath := strings.TrimPrefix(c.Request.URL.Path, "/dir")
+
+	pars := &DirListParamsSt{}
+	if !dopHttps.BindQuery(c, pars) {
+		return
+	}
+
+	if dopHttps.Error(c, dopTools.RequirePageSize(pars.ListParams, 1000)) {
+		return
+	}
+
+	result, tCount, err := a.core.Static.List(urlPath, &types.DirListParsSt{
+		ListParams: pars.ListParams,
+		Prefix:     pars.Prefix,
+	})
+	if err != nil {
+		if err == dopErrs.ObjectNotFound {
+			c.Status(http.StatusNotFound)
+		} else {
+			dopHttps.Error(c, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, dopTypes.PaginatedListRep{
+		Page:       pars.Page,
+		PageSize:   pars.PageSize,
+		TotalCount: tCount,
+		Results:    result,
+	})
+}
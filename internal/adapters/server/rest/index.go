@@ -2,6 +2,7 @@ package rest
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rendau/dop/adapters/logger"
@@ -13,11 +14,23 @@ import (
 )
 
 type St struct {
-	lg   logger.Lite
-	core *core.St
+	lg            logger.Lite
+	core          *core.St
+	authToken     string
+	urlSignSecret string
+	urlSignTtl    time.Duration
+	publicUrl     string
 }
 
-func GetHandler(lg logger.Lite, core *core.St, withCors bool) http.Handler {
+func GetHandler(
+	lg logger.Lite,
+	core *core.St,
+	withCors bool,
+	authToken string,
+	urlSignSecret string,
+	urlSignTtl time.Duration,
+	publicUrl string,
+) http.Handler {
 	gin.SetMode(gin.ReleaseMode)
 
 	r := gin.New()
@@ -37,7 +50,14 @@ func GetHandler(lg logger.Lite, core *core.St, withCors bool) http.Handler {
 		c.DocExpansion = "none"
 	}))
 
-	s := &St{lg: lg, core: core}
+	s := &St{
+		lg:            lg,
+		core:          core,
+		authToken:     authToken,
+		urlSignSecret: urlSignSecret,
+		urlSignTtl:    urlSignTtl,
+		publicUrl:     publicUrl,
+	}
 
 	// healthcheck
 	r.GET("/healthcheck", func(c *gin.Context) { c.Status(http.StatusOK) })
@@ -45,6 +65,30 @@ func GetHandler(lg logger.Lite, core *core.St, withCors bool) http.Handler {
 	// static
 	r.POST("/static", s.hStaticSave)
 	r.GET("/static/*any", s.hStaticGet)
+	r.HEAD("/static/*any", s.hStaticGet)
+	r.DELETE("/static/*any", s.hStaticRemove)
+
+	// upload
+	r.OPTIONS("/upload", s.hUploadOptions)
+	r.POST("/upload", s.hUploadCreate)
+	r.HEAD("/upload/:id", s.hUploadHead)
+	r.PATCH("/upload/:id", s.hUploadPatch)
+	r.DELETE("/upload/:id", s.hUploadRemove)
+
+	// meta
+	r.GET("/meta/*any", s.hStaticMetaGet)
+
+	// info
+	r.GET("/info/*any", s.hStaticInfoGet)
+
+	// srcset
+	r.GET("/srcset/*any", s.hStaticSrcsetGet)
+
+	// dir
+	r.GET("/dir/*any", s.hDirList)
+
+	// cache
+	r.GET("/cache/stats", s.hCacheStats)
 
 	// kvs
 	r.POST("/kvs/:key", s.hKvsSet)
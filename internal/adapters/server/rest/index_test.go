@@ -0,0 +1,260 @@
+package rest
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	dopLoggerZap "github.com/rendau/dop/adapters/logger/zap"
+	"github.com/rendau/dop/dopTypes"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rendau/kazan/internal/adapters/storage"
+	storageMock "github.com/rendau/kazan/internal/adapters/storage/mock"
+	"github.com/rendau/kazan/internal/cns"
+	"github.com/rendau/kazan/internal/domain/core"
+	"github.com/rendau/kazan/internal/domain/errs"
+	"github.com/rendau/kazan/pkg/urlsign"
+)
+
+const testUrlSignSecret = "secret"
+
+// gatedStorage holds reads of gatePath until gate is closed
+type gatedStorage struct {
+	*storageMock.St
+
+	gatePath string
+	gate     chan struct{}
+	entered  chan struct{}
+}
+
+func (s *gatedStorage) Get(p string) (io.ReadSeekCloser, error) {
+	if s.gatePath != "" && p == s.gatePath {
+		close(s.entered)
+		<-s.gate
+	}
+
+	return s.St.Get(p)
+}
+
+func newTestHandler(stg storage.Storage, imgOpts core.ImgOptionsSt, urlSignSecret string) (http.Handler, *core.St) {
+	lg := dopLoggerZap.New("info", true)
+
+	cr := core.New(
+		lg,
+		stg,
+		1000,
+		1000,
+		imgOpts,
+		core.CacheOptionsSt{},
+		core.ZipOptionsSt{CompressionLevel: flate.DefaultCompression},
+		core.UploadOptionsSt{Expiration: time.Hour, MaxSize: 100},
+		true,
+	)
+
+	return GetHandler(lg, cr, false, "", urlSignSecret, time.Minute, ""), cr
+}
+
+func doRequest(h http.Handler, method string, target string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func requireErrorCode(t *testing.T, rec *httptest.ResponseRecorder, code string) {
+	rep := dopTypes.ErrRep{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &rep))
+	require.Equal(t, code, rep.ErrorCode)
+}
+
+func testImg(t *testing.T) []byte {
+	buffer := new(bytes.Buffer)
+
+	err := imaging.Encode(buffer, imaging.New(100, 50, color.White), imaging.PNG)
+	require.Nil(t, err)
+
+	return buffer.Bytes()
+}
+
+func TestSignature(t *testing.T) {
+	h, cr := newTestHandler(storageMock.New(), core.ImgOptionsSt{}, testUrlSignSecret)
+
+	fPath, err := cr.Static.Create("photos", "a.png", bytes.NewReader(testImg(t)), false, false, nil, nil)
+	require.Nil(t, err)
+
+	// original is served without signature
+	rec := doRequest(h, http.MethodGet, "/static/"+fPath, nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(h, http.MethodGet, "/static/"+fPath+"?w=10", nil, nil)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	requireErrorCode(t, rec, errs.BadSignature.Error())
+
+	query := urlsign.Sign(testUrlSignSecret, fPath, url.Values{"w": {"10"}}, time.Now().Add(time.Minute))
+
+	rec = doRequest(h, http.MethodGet, "/static/"+fPath+"?"+query.Encode(), nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// params are covered by signature
+	query.Set("w", "20")
+
+	rec = doRequest(h, http.MethodGet, "/static/"+fPath+"?"+query.Encode(), nil, nil)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	requireErrorCode(t, rec, errs.BadSignature.Error())
+
+	query = urlsign.Sign(testUrlSignSecret, fPath, url.Values{"w": {"10"}}, time.Now().Add(-time.Minute))
+
+	rec = doRequest(h, http.MethodGet, "/static/"+fPath+"?"+query.Encode(), nil, nil)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	requireErrorCode(t, rec, errs.SignatureExpired.Error())
+
+	// signing is off without secret
+	h, cr = newTestHandler(storageMock.New(), core.ImgOptionsSt{}, "")
+
+	fPath, err = cr.Static.Create("photos", "a.png", bytes.NewReader(testImg(t)), false, false, nil, nil)
+	require.Nil(t, err)
+
+	rec = doRequest(h, http.MethodGet, "/static/"+fPath+"?w=10", nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestSrcset(t *testing.T) {
+	h, cr := newTestHandler(storageMock.New(), core.ImgOptionsSt{}, "")
+
+	fPath, err := cr.Static.Create("photos", "a.png", bytes.NewReader(testImg(t)), false, false, nil, nil)
+	require.Nil(t, err)
+
+	rec := doRequest(h, http.MethodGet, "/srcset/"+fPath+"?widths=20,50", nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// sizes depend on the page layout, only the client knows them
+	rep := map[string]string{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &rep))
+	require.Equal(t, map[string]string{
+		"src":    "/static/" + fPath + "?w=20",
+		"srcset": "/static/" + fPath + "?w=20 20w, /static/" + fPath + "?w=50 50w",
+	}, rep)
+}
+
+func TestUpload(t *testing.T) {
+	h, _ := newTestHandler(storageMock.New(), core.ImgOptionsSt{}, "")
+
+	tusHeaders := map[string]string{"Tus-Resumable": tusVersion}
+
+	rec := doRequest(h, http.MethodOptions, "/upload", nil, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, tusVersion, rec.Header().Get("Tus-Resumable"))
+	require.Equal(t, tusVersion, rec.Header().Get("Tus-Version"))
+	require.Equal(t, tusExtensions, rec.Header().Get("Tus-Extension"))
+	require.Equal(t, "100", rec.Header().Get("Tus-Max-Size"))
+
+	rec = doRequest(h, http.MethodPost, "/upload", nil, map[string]string{"Upload-Length": "10"})
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	require.Equal(t, tusVersion, rec.Header().Get("Tus-Version"))
+
+	rec = doRequest(h, http.MethodPost, "/upload", nil, map[string]string{"Tus-Resumable": tusVersion, "Upload-Length": "1000"})
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("a.txt")) + ",dir " + base64.StdEncoding.EncodeToString([]byte("docs"))
+
+	rec = doRequest(h, http.MethodPost, "/upload", nil, map[string]string{"Tus-Resumable": tusVersion, "Upload-Length": "10", "Upload-Metadata": metadata})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, "0", rec.Header().Get("Upload-Offset"))
+	require.NotEmpty(t, rec.Header().Get("Upload-Expires"))
+
+	location := rec.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, "/upload/"))
+
+	rec = doRequest(h, http.MethodHead, location, nil, tusHeaders)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "0", rec.Header().Get("Upload-Offset"))
+	require.Equal(t, "10", rec.Header().Get("Upload-Length"))
+	require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	patchHeaders := func(offset string) map[string]string {
+		return map[string]string{"Tus-Resumable": tusVersion, "Content-Type": "application/offset+octet-stream", "Upload-Offset": offset}
+	}
+
+	rec = doRequest(h, http.MethodPatch, location, strings.NewReader("0123"), map[string]string{"Tus-Resumable": tusVersion, "Upload-Offset": "0"})
+	require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	rec = doRequest(h, http.MethodPatch, location, strings.NewReader("0123"), patchHeaders("3"))
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, tusVersion, rec.Header().Get("Tus-Resumable"))
+
+	rec = doRequest(h, http.MethodPatch, location, strings.NewReader("0123"), patchHeaders("0"))
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "4", rec.Header().Get("Upload-Offset"))
+	require.Empty(t, rec.Header().Get("X-File-Path"))
+
+	rec = doRequest(h, http.MethodPatch, location, strings.NewReader("456789"), patchHeaders("4"))
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "10", rec.Header().Get("Upload-Offset"))
+
+	fPath := rec.Header().Get("X-File-Path")
+	require.True(t, strings.HasPrefix(fPath, "docs/"))
+
+	rec = doRequest(h, http.MethodGet, "/static/"+fPath, nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "0123456789", rec.Body.String())
+
+	rec = doRequest(h, http.MethodDelete, location, nil, tusHeaders)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doRequest(h, http.MethodHead, location, nil, tusHeaders)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestImgBusy(t *testing.T) {
+	stg := &gatedStorage{St: storageMock.New()}
+
+	h, cr := newTestHandler(stg, core.ImgOptionsSt{DecodeLimit: 1}, "")
+
+	fPath, err := cr.Static.Create("photos", "a.png", bytes.NewReader(testImg(t)), false, false, nil, nil)
+	require.Nil(t, err)
+
+	stg.gatePath = fPath
+	stg.gate = make(chan struct{})
+	stg.entered = make(chan struct{})
+
+	done := make(chan *httptest.ResponseRecorder)
+
+	go func() {
+		done <- doRequest(h, http.MethodGet, "/static/"+fPath+"?w=10", nil, nil)
+	}()
+
+	// the only decode slot is taken by the first request
+	<-stg.entered
+
+	rec := doRequest(h, http.MethodGet, "/static/"+fPath+"?w=20", nil, nil)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, strconv.Itoa(int(cns.ImgBusyRetryAfter.Seconds())), rec.Header().Get("Retry-After"))
+	requireErrorCode(t, rec, errs.ImgBusy.Error())
+
+	close(stg.gate)
+
+	rec = <-done
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	stg.gatePath = ""
+
+	rec = doRequest(h, http.MethodGet, "/static/"+fPath+"?w=20", nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
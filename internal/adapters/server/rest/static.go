@@ -1,18 +1,21 @@
 package rest
 
 import (
-	"bytes"
+	"mime"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	dopHttps "github.com/rendau/dop/adapters/server/https"
 	"github.com/rendau/dop/dopErrs"
+	"github.com/rendau/dop/dopTypes"
 
-	"github.com/rendau/kazan/internal/domain/types"
-
+	"github.com/rendau/kazan/internal/cns"
 	"github.com/rendau/kazan/internal/domain/errs"
+	"github.com/rendau/kazan/internal/domain/types"
 )
 
 // @Router  /static [post]
@@ -22,6 +25,7 @@ import (
 // @Param   body body     SaveReqSt false "body"
 // @Success 200  {object} SaveRepSt
 // @Failure 400  {object} dopTypes.ErrRep
+// @Failure 503  {object} dopTypes.ErrRep
 func (a *St) hStaticSave(c *gin.Context) {
 	var err error
 
@@ -49,22 +53,42 @@ func (a *St) hStaticSave(c *gin.Context) {
 		f,
 		reqObj.NoCut,
 		reqObj.ExtractZip,
+		reqObj.StripMeta,
+		reqObj.Tags,
 	)
-	if dopHttps.Error(c, err) {
+	if err != nil {
+		a.staticError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, SaveRepSt{Path: result})
+	rep := SaveRepSt{Path: result}
+
+	if reqObj.Placeholder {
+		placeholder, err := a.core.Static.Placeholder(result)
+		if err == nil {
+			rep.BlurHash = placeholder.BlurHash
+			rep.Lqip = placeholder.Lqip
+		} else if err != errs.NotImg && err != errs.ImgTooLarge && err != errs.ImgBusy && err != dopErrs.ObjectNotFound {
+			dopHttps.Error(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, rep)
 }
 
 // @Router  /static/:path [get]
+// @Router  /static/:path [head]
 // @Tags    static
 // @Summary Get or download file.
+// @Description With placeholder=true returns JSON types.ImgPlaceholderSt instead of the file, it is not given for watermark directories.
 // @Param   path  path  string      true  "path"
 // @Param   query query GetParamsSt false "query"
 // @Produce octet-stream
 // @Success 200
 // @Failure 400 {object} dopTypes.ErrRep
+// @Failure 403 {object} dopTypes.ErrRep
+// @Failure 503 {object} dopTypes.ErrRep
 func (a *St) hStaticGet(c *gin.Context) {
 	var err error
 
@@ -79,13 +103,112 @@ func (a *St) hStaticGet(c *gin.Context) {
 		return
 	}
 
-	fName, fModTime, fData, err := a.core.Static.Get(urlPath, &types.ImgParsSt{
+	if !a.checkSignature(c, urlPath, pars) {
+		return
+	}
+
+	if pars.Placeholder {
+		placeholder, err := a.core.Static.Placeholder(urlPath)
+		if err != nil {
+			a.staticError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, placeholder)
+		return
+	}
+
+	imgPars, err := a.core.Img.ResolvePars(pars.Preset, &types.ImgParsSt{
 		Method:    pars.M,
 		Width:     pars.W,
 		Height:    pars.H,
 		Blur:      pars.Blur,
 		Grayscale: pars.Grayscale,
-	}, pars.Download != "")
+		WMark:     pars.Wmark,
+		Dpr:       pars.Dpr,
+		Gravity:   pars.G,
+		Format:    pars.F,
+		Frame:     pars.Frame,
+
+		CropX: pars.Cx,
+		CropY: pars.Cy,
+		CropW: pars.Cw,
+		CropH: pars.Ch,
+
+		Rotate:     pars.Rot,
+		Background: pars.Bg,
+		FlipH:      pars.FlipH,
+		FlipV:      pars.FlipV,
+		Sharpen:    pars.Sharpen,
+		Brightness: pars.Brightness,
+		Contrast:   pars.Contrast,
+		Gamma:      pars.Gamma,
+		Saturation: pars.Saturation,
+		Invert:     pars.Invert,
+
+		Quality:        pars.Q,
+		PngCompression: pars.PngC,
+	})
+	if dopHttps.Error(c, err) {
+		return
+	}
+
+	if imgPars.Format == "auto" {
+		imgPars.Format = a.core.Img.NegotiateFormat(c.GetHeader("Accept"))
+		c.Header("Vary", "Accept")
+	}
+
+	file, err := a.core.Static.Get(urlPath, imgPars, pars.Download != "")
+	if err != nil {
+		a.staticError(c, err)
+		return
+	}
+	defer file.Close()
+
+	if pars.Download != "" {
+		pars.Download += path.Ext(file.Name)
+		c.Header("Content-Type", `application/octet-stream`)
+		c.Header("Content-Disposition", `attachment; filename="`+pars.Download+`"`)
+	} else if file.Meta != nil && file.Meta.OriginalName != "" {
+		c.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": file.Meta.OriginalName}))
+	}
+
+	if file.Meta != nil && file.Meta.Sha256 != "" {
+		c.Header("X-Checksum-Sha256", file.Meta.Sha256)
+	}
+
+	if file.Stream != nil {
+		c.Status(http.StatusOK)
+
+		err = file.Stream(c.Writer)
+		if err != nil {
+			if !c.Writer.Written() {
+				dopHttps.Error(c, err)
+			} else {
+				a.lg.Errorw("Fail to stream file", err, "path", urlPath)
+			}
+		}
+
+		return
+	}
+
+	http.ServeContent(c.Writer, c.Request, file.Name, file.ModTime, file.Content)
+}
+
+// @Router  /static/:path [delete]
+// @Tags    static
+// @Summary Remove file or ZIP directory.
+// @Param   path path string true "path"
+// @Success 200
+// @Failure 400 {object} dopTypes.ErrRep
+func (a *St) hStaticRemove(c *gin.Context) {
+	if !a.checkAuth(c) {
+		return
+	}
+
+	urlPath := strings.TrimPrefix(c.Request.URL.Path, "/static")
+
+	err := a.core.Static.Remove(urlPath)
 	if err != nil {
 		if err == dopErrs.ObjectNotFound {
 			c.Status(http.StatusNotFound)
@@ -95,11 +218,145 @@ func (a *St) hStaticGet(c *gin.Context) {
 		return
 	}
 
-	if pars.Download != "" {
-		pars.Download += path.Ext(fName)
-		c.Header("Content-Type", `application/octet-stream`)
-		c.Header("Content-Disposition", `attachment; filename="`+pars.Download+`"`)
+	c.Status(http.StatusOK)
+}
+
+// @Router  /meta/:path [get]
+// @Tags    static
+// @Summary Get metadata of file or ZIP directory.
+// @Param   path path string true "path"
+// @Produce json
+// @Success 200 {object} types.StaticMetaSt
+// @Failure 400 {object} dopTypes.ErrRep
+func (a *St) hStaticMetaGet(c *gin.Context) {
+	urlPath := strings.TrimPrefix(c.Request.URL.Path, "/meta")
+
+	result, err := a.core.Static.GetMeta(urlPath)
+	if err != nil {
+		if err == dopErrs.ObjectNotFound {
+			c.Status(http.StatusNotFound)
+		} else {
+			dopHttps.Error(c, err)
+		}
+		return
+	}
+	if result == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// @Router  /info/:path [get]
+// @Tags    static
+// @Summary Get properties of image: dimensions, format, EXIF, dominant color.
+// @Param   path path string true "path"
+// @Produce json
+// @Success 200 {object} types.ImgInfoSt
+// @Failure 400 {object} dopTypes.ErrRep
+// @Failure 503 {object} dopTypes.ErrRep
+func (a *St) hStaticInfoGet(c *gin.Context) {
+	urlPath := strings.TrimPrefix(c.Request.URL.Path, "/info")
+
+	result, err := a.core.Static.Info(urlPath)
+	if err != nil {
+		a.staticError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// @Router  /srcset/:path [get]
+// @Tags    static
+// @Summary Get srcset of image for preset (pixel ratios) or list of widths.
+// @Description Urls are signed if signing is configured, then auth token is required.
+// @Description Sizes attribute depends on the page layout, so it is left to the client.
+// @Param   path  path  string         true  "path"
+// @Param   query query SrcsetParamsSt false "query"
+// @Produce json
+// @Success 200 {object} SrcsetRepSt
+// @Failure 400 {object} dopTypes.ErrRep
+func (a *St) hStaticSrcsetGet(c *gin.Context) {
+	// signed urls are given only to authorized clients
+	if a.urlSignSecret != "" && !a.checkAuth(c) {
+		return
+	}
+
+	urlPath := strings.TrimPrefix(c.Request.URL.Path, "/srcset")
+
+	pars := &SrcsetParamsSt{}
+	if !dopHttps.BindQuery(c, pars) {
+		return
+	}
+
+	widths := make([]int, 0)
+
+	if pars.Widths != "" {
+		for _, v := range strings.Split(pars.Widths, ",") {
+			w, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				dopHttps.Error(c, dopErrs.ErrWithDesc{Err: errs.BadImgParams, Desc: "bad widths"})
+				return
+			}
+			widths = append(widths, w)
+		}
+	}
+
+	items, err := a.core.Static.Srcset(urlPath, pars.Preset, widths)
+	if err != nil {
+		if err == dopErrs.ObjectNotFound {
+			c.Status(http.StatusNotFound)
+		} else {
+			dopHttps.Error(c, err)
+		}
+		return
+	}
+
+	result := SrcsetRepSt{}
+
+	srcset := make([]string, 0, len(items))
+
+	for i, item := range items {
+		query := url.Values{}
+
+		var descriptor string
+
+		if pars.Preset != "" {
+			query.Set("preset", pars.Preset)
+			if item.Dpr != 1 {
+				query.Set("dpr", strconv.FormatFloat(item.Dpr, 'f', -1, 64))
+			}
+			descriptor = strconv.FormatFloat(item.Dpr, 'f', -1, 64) + "x"
+		} else {
+			query.Set("w", strconv.Itoa(item.Width))
+			descriptor = strconv.Itoa(item.Width) + "w"
+		}
+
+		itemUrl := a.staticUrl(urlPath, query)
+
+		if i == 0 {
+			result.Src = itemUrl
+		}
+
+		srcset = append(srcset, itemUrl+" "+descriptor)
 	}
 
-	http.ServeContent(c.Writer, c.Request, fName, fModTime, bytes.NewReader(fData))
+	result.Srcset = strings.Join(srcset, ", ")
+
+	c.JSON(http.StatusOK, result)
+}
+
+// staticError responds to errors of reading files, 503 is sent when all image decode slots are taken
+func (a *St) staticError(c *gin.Context, err error) {
+	switch err {
+	case dopErrs.ObjectNotFound:
+		c.Status(http.StatusNotFound)
+	case errs.ImgBusy:
+		c.Header("Retry-After", strconv.Itoa(int(cns.ImgBusyRetryAfter.Seconds())))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, dopTypes.ErrRep{ErrorCode: err.Error()})
+	default:
+		dopHttps.Error(c, err)
+	}
 }
@@ -2,24 +2,72 @@ package rest
 
 import (
 	"mime/multipart"
+
+	"github.com/rendau/dop/dopTypes"
 )
 
 type SaveReqSt struct {
-	Dir        string                `json:"dir" form:"dir" binding:"required"`
-	File       *multipart.FileHeader `json:"file" form:"file" binding:"required" swaggertype:"string"`
-	NoCut      bool                  `json:"no_cut" form:"no_cut"`
-	ExtractZip bool                  `json:"extract_zip" form:"extract_zip"`
+	Dir         string                `json:"dir" form:"dir" binding:"required"`
+	File        *multipart.FileHeader `json:"file" form:"file" binding:"required" swaggertype:"string"`
+	NoCut       bool                  `json:"no_cut" form:"no_cut"`
+	ExtractZip  bool                  `json:"extract_zip" form:"extract_zip"`
+	StripMeta   *bool                 `json:"strip_meta" form:"strip_meta"`
+	Placeholder bool                  `json:"placeholder" form:"placeholder"`
+	Tags        []string              `json:"tags" form:"tags"`
 }
 
 type SaveRepSt struct {
-	Path string `json:"path"`
+	Path     string `json:"path"`
+	BlurHash string `json:"blur_hash,omitempty"`
+	Lqip     string `json:"lqip,omitempty"`
 }
 
 type GetParamsSt struct {
-	W         int     `json:"w" form:"w"`
-	H         int     `json:"h" form:"h"`
-	M         string  `json:"m" form:"m"`
-	Blur      float64 `json:"blur" form:"blur"`
-	Grayscale bool    `json:"grayscale" form:"grayscale"`
-	Download  string  `json:"download" form:"download"`
+	Preset      string  `json:"preset" form:"preset"`
+	W           int     `json:"w" form:"w"`
+	H           int     `json:"h" form:"h"`
+	M           string  `json:"m" form:"m" enums:"fill,fit,crop"`
+	Blur        float64 `json:"blur" form:"blur"`
+	Grayscale   bool    `json:"grayscale" form:"grayscale"`
+	G           string  `json:"g" form:"g" enums:"center,top,bottom,left,right,top_left,top_right,bottom_left,bottom_right,smart"`
+	Cx          int     `json:"cx" form:"cx"`
+	Cy          int     `json:"cy" form:"cy"`
+	Cw          int     `json:"cw" form:"cw"`
+	Ch          int     `json:"ch" form:"ch"`
+	Rot         float64 `json:"rot" form:"rot"`
+	Bg          string  `json:"bg" form:"bg"`
+	FlipH       bool    `json:"flip_h" form:"flip_h"`
+	FlipV       bool    `json:"flip_v" form:"flip_v"`
+	Sharpen     float64 `json:"sharpen" form:"sharpen"`
+	Brightness  float64 `json:"brightness" form:"brightness"`
+	Contrast    float64 `json:"contrast" form:"contrast"`
+	Gamma       float64 `json:"gamma" form:"gamma"`
+	Saturation  float64 `json:"saturation" form:"saturation"`
+	Invert      bool    `json:"invert" form:"invert"`
+	Wmark       bool    `json:"wmark" form:"wmark"`
+	F           string  `json:"f" form:"f" enums:"jpeg,png,webp,avif,gif,auto"`
+	Frame       *int    `json:"frame" form:"frame"`
+	Q           int     `json:"q" form:"q"`
+	PngC        string  `json:"png_c" form:"png_c" enums:"default,no,speed,best"`
+	Dpr         float64 `json:"dpr" form:"dpr"`
+	Placeholder bool    `json:"placeholder" form:"placeholder"`
+	Download    string  `json:"download" form:"download"`
+	Exp         int64   `json:"exp" form:"exp"`
+	Sig         string  `json:"sig" form:"sig"`
+}
+
+type DirListParamsSt struct {
+	dopTypes.ListParams
+
+	Prefix string `json:"prefix" form:"prefix"`
+}
+
+type SrcsetParamsSt struct {
+	Preset string `json:"preset" form:"preset"`
+	Widths string `json:"widths" form:"widths"` // comma separated
+}
+
+type SrcsetRepSt struct {
+	Src    string `json:"src"`
+	Srcset string `json:"srcset"`
 }
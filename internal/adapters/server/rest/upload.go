@@ -0,0 +1,255 @@
+package rest
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	dopHttps "github.com/rendau/dop/adapters/server/https"
+	"github.com/rendau/dop/dopErrs"
+
+	"github.com/rendau/kazan/internal/cns"
+	"github.com/rendau/kazan/internal/domain/errs"
+	"github.com/rendau/kazan/internal/domain/types"
+)
+
+// Resumable uploads, tus 1.0 protocol (https://tus.io/protocols/resumable-upload)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,expiration,termination"
+)
+
+// @Router  /upload [options]
+// @Tags    upload
+// @Summary Get tus server capabilities.
+// @Success 204
+func (a *St) hUploadOptions(c *gin.Context) {
+	a.tusHeaders(c)
+
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+
+	if maxSize := a.core.Upload.MaxSize(); maxSize > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Router  /upload [post]
+// @Tags    upload
+// @Summary Create resumable upload.
+// @Description Upload-Metadata keys: filename, dir, no_cut, extract_zip, strip_meta, tags (comma separated).
+// @Description Result path of completed upload is returned in X-File-Path header.
+// @Param   Tus-Resumable   header string true  "1.0.0"
+// @Param   Upload-Length   header int    true  "length"
+// @Param   Upload-Metadata header string false "metadata"
+// @Success 201
+// @Failure 400 {object} dopTypes.ErrRep
+func (a *St) hUploadCreate(c *gin.Context) {
+	if !a.tusCheckVersion(c) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil {
+		dopHttps.Error(c, dopErrs.ErrWithDesc{Err: errs.BadFormData, Desc: "bad Upload-Length"})
+		return
+	}
+
+	metadata := a.tusParseMetadata(c.GetHeader("Upload-Metadata"))
+
+	obj := &types.UploadSt{
+		Length:     length,
+		Dir:        metadata["dir"],
+		FileName:   metadata["filename"],
+		NoCut:      metadata["no_cut"] == "true",
+		ExtractZip: metadata["extract_zip"] == "true",
+	}
+
+	if tags := metadata["tags"]; tags != "" {
+		obj.Tags = strings.Split(tags, ",")
+	}
+
+	if stripMeta, ok := metadata["strip_meta"]; ok {
+		v := stripMeta == "true"
+		obj.StripMeta = &v
+	}
+
+	obj, err = a.core.Upload.Create(obj)
+	if err != nil {
+		a.tusError(c, err)
+		return
+	}
+
+	// creation-with-upload
+	if c.Request.ContentLength > 0 && c.GetHeader("Content-Type") == "application/offset+octet-stream" {
+		obj, err = a.core.Upload.Append(obj.Id, 0, c.Request.Body)
+		if err != nil {
+			a.tusError(c, err)
+			return
+		}
+	}
+
+	a.tusHeaders(c)
+	a.tusUploadHeaders(c, obj)
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+obj.Id)
+
+	c.Status(http.StatusCreated)
+}
+
+// @Router  /upload/:id [head]
+// @Tags    upload
+// @Summary Get offset of resumable upload.
+// @Param   id            path   string true "id"
+// @Param   Tus-Resumable header string true "1.0.0"
+// @Success 200
+// @Failure 404
+func (a *St) hUploadHead(c *gin.Context) {
+	if !a.tusCheckVersion(c) {
+		return
+	}
+
+	obj, err := a.core.Upload.Get(c.Param("id"))
+	if err != nil {
+		a.tusError(c, err)
+		return
+	}
+
+	a.tusHeaders(c)
+	a.tusUploadHeaders(c, obj)
+	c.Header("Upload-Length", strconv.FormatInt(obj.Length, 10))
+	c.Header("Cache-Control", "no-store")
+
+	c.Status(http.StatusOK)
+}
+
+// @Router  /upload/:id [patch]
+// @Tags    upload
+// @Summary Upload next chunk of resumable upload.
+// @Accept  application/offset+octet-stream
+// @Param   id            path   string true "id"
+// @Param   Tus-Resumable header string true "1.0.0"
+// @Param   Upload-Offset header int    true "offset"
+// @Success 204
+// @Failure 400 {object} dopTypes.ErrRep
+func (a *St) hUploadPatch(c *gin.Context) {
+	if !a.tusCheckVersion(c) {
+		return
+	}
+
+	if c.GetHeader("Content-Type") != "application/offset+octet-stream" {
+		a.tusHeaders(c)
+		c.Status(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		dopHttps.Error(c, dopErrs.ErrWithDesc{Err: errs.BadFormData, Desc: "bad Upload-Offset"})
+		return
+	}
+
+	obj, err := a.core.Upload.Append(c.Param("id"), offset, c.Request.Body)
+	if err != nil {
+		a.tusError(c, err)
+		return
+	}
+
+	a.tusHeaders(c)
+	a.tusUploadHeaders(c, obj)
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Router  /upload/:id [delete]
+// @Tags    upload
+// @Summary Terminate resumable upload.
+// @Param   id            path   string true "id"
+// @Param   Tus-Resumable header string true "1.0.0"
+// @Success 204
+// @Failure 404
+func (a *St) hUploadRemove(c *gin.Context) {
+	if !a.tusCheckVersion(c) {
+		return
+	}
+
+	err := a.core.Upload.Remove(c.Param("id"))
+	if err != nil {
+		a.tusError(c, err)
+		return
+	}
+
+	a.tusHeaders(c)
+
+	c.Status(http.StatusNoContent)
+}
+
+func (a *St) tusCheckVersion(c *gin.Context) bool {
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		a.tusHeaders(c)
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+func (a *St) tusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires, X-File-Path")
+}
+
+func (a *St) tusUploadHeaders(c *gin.Context, obj *types.UploadSt) {
+	c.Header("Upload-Offset", strconv.FormatInt(obj.Offset, 10))
+	c.Header("Upload-Expires", obj.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	if obj.IsCompleted() {
+		c.Header("X-File-Path", obj.ResultPath)
+	}
+}
+
+func (a *St) tusError(c *gin.Context, err error) {
+	a.tusHeaders(c)
+
+	switch err {
+	case dopErrs.ObjectNotFound:
+		c.Status(http.StatusNotFound)
+	case errs.BadUploadOffset:
+		c.Status(http.StatusConflict)
+	case errs.UploadLocked:
+		c.Status(http.StatusLocked)
+	case errs.UploadTooLarge:
+		c.Status(http.StatusRequestEntityTooLarge)
+	case errs.ImgBusy:
+		c.Header("Retry-After", strconv.Itoa(int(cns.ImgBusyRetryAfter.Seconds())))
+		c.Status(http.StatusServiceUnavailable)
+	default:
+		dopHttps.Error(c, err)
+	}
+}
+
+// tusParseMetadata parses "key base64value,key2 base64value2"
+func (a *St) tusParseMetadata(v string) map[string]string {
+	result := map[string]string{}
+
+	for _, pair := range strings.Split(v, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		valueRaw, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+
+		result[key] = string(valueRaw)
+	}
+
+	return result
+}
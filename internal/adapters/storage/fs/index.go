@@ -0,0 +1,180 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/rendau/dop/adapters/logger"
+	"github.com/rendau/dop/dopErrs"
+
+	"github.com/rendau/kazan/internal/adapters/storage"
+)
+
+type St struct {
+	lg      logger.Lite
+	dirPath string
+}
+
+func New(lg logger.Lite, dirPath string) *St {
+	return &St{
+		lg:      lg,
+		dirPath: dirPath,
+	}
+}
+
+func (s *St) Put(p string, r io.Reader) error {
+	absPath := s.absPath(p)
+
+	err := os.MkdirAll(filepath.Dir(absPath), os.ModePerm)
+	if err != nil {
+		s.lg.Errorw("Fail to create dirs", err, "path", absPath)
+		return err
+	}
+
+	// write into a temp-file first, so readers never see partial content
+	f, err := os.CreateTemp(filepath.Dir(absPath), ".tmp_*")
+	if err != nil {
+		s.lg.Errorw("Fail to create temp-file", err)
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		s.lg.Errorw("Fail to copy data", err)
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		_ = os.Remove(f.Name())
+		s.lg.Errorw("Fail to close file", err)
+		return err
+	}
+
+	err = os.Rename(f.Name(), absPath)
+	if err != nil {
+		_ = os.Remove(f.Name())
+		s.lg.Errorw("Fail to rename file", err, "path", absPath)
+		return err
+	}
+
+	return nil
+}
+
+func (s *St) Get(p string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(s.absPath(p))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, dopErrs.ObjectNotFound
+		}
+		s.lg.Errorw("Fail to open file", err, "path", p)
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (s *St) Stat(p string) (*storage.FileInfoSt, error) {
+	fInfo, err := os.Stat(s.absPath(p))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, dopErrs.ObjectNotFound
+		}
+		s.lg.Errorw("Fail to get stat of file", err, "path", p)
+		return nil, err
+	}
+
+	return s.fileInfo(fInfo), nil
+}
+
+func (s *St) List(dirPath string) ([]*storage.FileInfoSt, error) {
+	entries, err := os.ReadDir(s.absPath(dirPath))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, dopErrs.ObjectNotFound
+		}
+		s.lg.Errorw("Fail to read dir", err, "path", dirPath)
+		return nil, err
+	}
+
+	result := make([]*storage.FileInfoSt, 0, len(entries))
+
+	for _, entry := range entries {
+		fInfo, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			s.lg.Errorw("Fail to get stat of file", err, "path", path.Join(dirPath, entry.Name()))
+			return nil, err
+		}
+
+		result = append(result, s.fileInfo(fInfo))
+	}
+
+	return result, nil
+}
+
+func (s *St) Remove(p string) error {
+	absPath := s.absPath(p)
+
+	if absPath == filepath.Clean(s.dirPath) {
+		return dopErrs.PermissionDenied
+	}
+
+	_, err := os.Lstat(absPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return dopErrs.ObjectNotFound
+		}
+		s.lg.Errorw("Fail to get stat of file", err, "path", p)
+		return err
+	}
+
+	err = os.RemoveAll(absPath)
+	if err != nil {
+		s.lg.Errorw("Fail to remove", err, "path", p)
+		return err
+	}
+
+	return nil
+}
+
+func (s *St) Rename(oldPath, newPath string) error {
+	absNewPath := s.absPath(newPath)
+
+	err := os.MkdirAll(filepath.Dir(absNewPath), os.ModePerm)
+	if err != nil {
+		s.lg.Errorw("Fail to create dirs", err, "path", absNewPath)
+		return err
+	}
+
+	err = os.Rename(s.absPath(oldPath), absNewPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return dopErrs.ObjectNotFound
+		}
+		s.lg.Errorw("Fail to rename", err, "old_path", oldPath, "new_path", newPath)
+		return err
+	}
+
+	return nil
+}
+
+func (s *St) absPath(p string) string {
+	return filepath.Join(s.dirPath, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+func (s *St) fileInfo(fInfo os.FileInfo) *storage.FileInfoSt {
+	return &storage.FileInfoSt{
+		Name:    fInfo.Name(),
+		Size:    fInfo.Size(),
+		ModTime: fInfo.ModTime(),
+		IsDir:   fInfo.IsDir(),
+	}
+}
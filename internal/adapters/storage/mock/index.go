@@ -0,0 +1,238 @@
+package mock
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rendau/dop/dopErrs"
+
+	"github.com/rendau/kazan/internal/adapters/storage"
+)
+
+type St struct {
+	files map[string]*fileSt
+	mu    sync.RWMutex
+}
+
+type fileSt struct {
+	data    []byte
+	modTime time.Time
+}
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+func New() *St {
+	return &St{
+		files: map[string]*fileSt{},
+	}
+}
+
+func (s *St) Put(p string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[s.normPath(p)] = &fileSt{
+		data:    data,
+		modTime: time.Now(),
+	}
+
+	return nil
+}
+
+func (s *St) Get(p string) (io.ReadSeekCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.files[s.normPath(p)]
+	if !ok {
+		return nil, dopErrs.ObjectNotFound
+	}
+
+	return readSeekNopCloser{bytes.NewReader(f.data)}, nil
+}
+
+func (s *St) Stat(p string) (*storage.FileInfoSt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p = s.normPath(p)
+
+	if f, ok := s.files[p]; ok {
+		return &storage.FileInfoSt{
+			Name:    path.Base(p),
+			Size:    int64(len(f.data)),
+			ModTime: f.modTime,
+		}, nil
+	}
+
+	result := &storage.FileInfoSt{
+		Name:  path.Base(p),
+		IsDir: true,
+	}
+
+	found := false
+
+	for fPath, f := range s.files {
+		if !s.isInDir(fPath, p) {
+			continue
+		}
+
+		found = true
+
+		if f.modTime.After(result.ModTime) {
+			result.ModTime = f.modTime
+		}
+	}
+
+	if !found {
+		return nil, dopErrs.ObjectNotFound
+	}
+
+	return result, nil
+}
+
+func (s *St) List(dirPath string) ([]*storage.FileInfoSt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dirPath = s.normPath(dirPath)
+
+	items := map[string]*storage.FileInfoSt{}
+
+	for fPath, f := range s.files {
+		if !s.isInDir(fPath, dirPath) {
+			continue
+		}
+
+		relPath := strings.TrimPrefix(fPath, dirPath+"/")
+		if dirPath == "" {
+			relPath = fPath
+		}
+
+		name, _, isDir := strings.Cut(relPath, "/")
+
+		item, ok := items[name]
+		if !ok {
+			item = &storage.FileInfoSt{
+				Name:  name,
+				IsDir: isDir,
+			}
+			items[name] = item
+		}
+
+		if !isDir {
+			item.Size = int64(len(f.data))
+		}
+
+		if f.modTime.After(item.ModTime) {
+			item.ModTime = f.modTime
+		}
+	}
+
+	if len(items) == 0 && dirPath != "" {
+		return nil, dopErrs.ObjectNotFound
+	}
+
+	result := make([]*storage.FileInfoSt, 0, len(items))
+
+	for _, item := range items {
+		result = append(result, item)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+func (s *St) Remove(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p = s.normPath(p)
+
+	if p == "" {
+		return dopErrs.PermissionDenied
+	}
+
+	if _, ok := s.files[p]; ok {
+		delete(s.files, p)
+		return nil
+	}
+
+	found := false
+
+	for fPath := range s.files {
+		if s.isInDir(fPath, p) {
+			delete(s.files, fPath)
+			found = true
+		}
+	}
+
+	if !found {
+		return dopErrs.ObjectNotFound
+	}
+
+	return nil
+}
+
+func (s *St) Rename(oldPath, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldPath = s.normPath(oldPath)
+	newPath = s.normPath(newPath)
+
+	if f, ok := s.files[oldPath]; ok {
+		delete(s.files, oldPath)
+		s.files[newPath] = f
+		return nil
+	}
+
+	found := false
+
+	for fPath, f := range s.files {
+		if s.isInDir(fPath, oldPath) {
+			delete(s.files, fPath)
+			s.files[newPath+strings.TrimPrefix(fPath, oldPath)] = f
+			found = true
+		}
+	}
+
+	if !found {
+		return dopErrs.ObjectNotFound
+	}
+
+	return nil
+}
+
+// Clean removes all files
+func (s *St) Clean() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files = map[string]*fileSt{}
+}
+
+func (s *St) normPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+func (s *St) isInDir(fPath, dirPath string) bool {
+	return dirPath == "" || strings.HasPrefix(fPath, dirPath+"/")
+}
@@ -0,0 +1,309 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/rendau/dop/adapters/logger"
+	"github.com/rendau/dop/dopErrs"
+
+	"github.com/rendau/kazan/internal/adapters/storage"
+)
+
+type OptionsSt struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Region    string
+	Bucket    string
+	Prefix    string
+	UseSsl    bool
+}
+
+type St struct {
+	lg     logger.Lite
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func New(lg logger.Lite, opts OptionsSt) (*St, error) {
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure:       opts.UseSsl,
+		Region:       opts.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		lg.Errorw("Fail to create s3 client", err, "endpoint", opts.Endpoint)
+		return nil, err
+	}
+
+	return &St{
+		lg:     lg,
+		client: client,
+		bucket: opts.Bucket,
+		prefix: strings.Trim(opts.Prefix, "/"),
+	}, nil
+}
+
+// EnsureBucket creates the bucket if it does not exist
+func (s *St) EnsureBucket() error {
+	ctx := context.Background()
+
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		s.lg.Errorw("Fail to check bucket existence", err, "bucket", s.bucket)
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	err = s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{})
+	if err != nil {
+		s.lg.Errorw("Fail to create bucket", err, "bucket", s.bucket)
+		return err
+	}
+
+	return nil
+}
+
+func (s *St) Put(p string, r io.Reader) error {
+	key := s.key(p)
+
+	_, err := s.client.PutObject(context.Background(), s.bucket, key, r, -1, minio.PutObjectOptions{
+		ContentType:          mime.TypeByExtension(path.Ext(key)),
+		DisableContentSha256: true,
+	})
+	if err != nil {
+		s.lg.Errorw("Fail to put object", err, "key", key)
+		return err
+	}
+
+	return nil
+}
+
+func (s *St) Get(p string) (io.ReadSeekCloser, error) {
+	key := s.key(p)
+
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		s.lg.Errorw("Fail to get object", err, "key", key)
+		return nil, err
+	}
+
+	// GetObject is lazy, so stat it to find out if object exists
+	_, err = obj.Stat()
+	if err != nil {
+		_ = obj.Close()
+		if s.isNotFound(err) {
+			return nil, dopErrs.ObjectNotFound
+		}
+		s.lg.Errorw("Fail to get object", err, "key", key)
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func (s *St) Stat(p string) (*storage.FileInfoSt, error) {
+	key := s.key(p)
+
+	if key != s.prefix {
+		objInfo, err := s.client.StatObject(context.Background(), s.bucket, key, minio.StatObjectOptions{})
+		if err == nil {
+			return &storage.FileInfoSt{
+				Name:    path.Base(key),
+				Size:    objInfo.Size,
+				ModTime: objInfo.LastModified,
+			}, nil
+		}
+		if !s.isNotFound(err) {
+			s.lg.Errorw("Fail to stat object", err, "key", key)
+			return nil, err
+		}
+	}
+
+	// there are no real directories in s3, so treat any non-empty prefix as a directory.
+	// One object is enough to know it, directories have no modification time.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for objInfo := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.dirPrefix(key),
+		Recursive: true,
+		MaxKeys:   1,
+	}) {
+		if objInfo.Err != nil {
+			s.lg.Errorw("Fail to list objects", objInfo.Err, "prefix", key)
+			return nil, objInfo.Err
+		}
+
+		return &storage.FileInfoSt{
+			Name:  path.Base(key),
+			IsDir: true,
+		}, nil
+	}
+
+	return nil, dopErrs.ObjectNotFound
+}
+
+func (s *St) List(dirPath string) ([]*storage.FileInfoSt, error) {
+	prefix := s.dirPrefix(s.key(dirPath))
+
+	result := make([]*storage.FileInfoSt, 0)
+
+	for objInfo := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{
+		Prefix: prefix,
+	}) {
+		if objInfo.Err != nil {
+			s.lg.Errorw("Fail to list objects", objInfo.Err, "prefix", prefix)
+			return nil, objInfo.Err
+		}
+
+		name := strings.TrimPrefix(objInfo.Key, prefix)
+
+		if strings.HasSuffix(name, "/") {
+			result = append(result, &storage.FileInfoSt{
+				Name:  strings.TrimSuffix(name, "/"),
+				IsDir: true,
+			})
+		} else {
+			result = append(result, &storage.FileInfoSt{
+				Name:    name,
+				Size:    objInfo.Size,
+				ModTime: objInfo.LastModified,
+			})
+		}
+	}
+
+	if len(result) == 0 && strings.Trim(dirPath, "/") != "" {
+		return nil, dopErrs.ObjectNotFound
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+func (s *St) Remove(p string) error {
+	key := s.key(p)
+
+	if key == s.prefix {
+		return dopErrs.PermissionDenied
+	}
+
+	keys, err := s.keysOf(key)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		err = s.client.RemoveObject(context.Background(), s.bucket, k, minio.RemoveObjectOptions{})
+		if err != nil {
+			s.lg.Errorw("Fail to remove object", err, "key", k)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *St) Rename(oldPath, newPath string) error {
+	oldKey := s.key(oldPath)
+	newKey := s.key(newPath)
+
+	keys, err := s.keysOf(oldKey)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		dstKey := newKey + strings.TrimPrefix(k, oldKey)
+
+		_, err = s.client.CopyObject(
+			context.Background(),
+			minio.CopyDestOptions{Bucket: s.bucket, Object: dstKey},
+			minio.CopySrcOptions{Bucket: s.bucket, Object: k},
+		)
+		if err != nil {
+			s.lg.Errorw("Fail to copy object", err, "src", k, "dst", dstKey)
+			return err
+		}
+
+		err = s.client.RemoveObject(context.Background(), s.bucket, k, minio.RemoveObjectOptions{})
+		if err != nil {
+			s.lg.Errorw("Fail to remove object", err, "key", k)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keysOf returns key itself if it is an object, or all keys under it if it is a directory
+func (s *St) keysOf(key string) ([]string, error) {
+	_, err := s.client.StatObject(context.Background(), s.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return []string{key}, nil
+	}
+	if !s.isNotFound(err) {
+		s.lg.Errorw("Fail to stat object", err, "key", key)
+		return nil, err
+	}
+
+	result := make([]string, 0)
+
+	for objInfo := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.dirPrefix(key),
+		Recursive: true,
+	}) {
+		if objInfo.Err != nil {
+			s.lg.Errorw("Fail to list objects", objInfo.Err, "prefix", key)
+			return nil, objInfo.Err
+		}
+
+		if strings.HasSuffix(objInfo.Key, "/") { // directory marker
+			continue
+		}
+
+		result = append(result, objInfo.Key)
+	}
+
+	if len(result) == 0 {
+		return nil, dopErrs.ObjectNotFound
+	}
+
+	return result, nil
+}
+
+func (s *St) key(p string) string {
+	return strings.Trim(path.Join(s.prefix, path.Clean("/"+p)), "/")
+}
+
+func (s *St) dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+
+	return key + "/"
+}
+
+func (s *St) isNotFound(err error) bool {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return true
+	}
+
+	return false
+}
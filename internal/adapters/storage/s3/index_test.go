@@ -0,0 +1,119 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	dopLoggerZap "github.com/rendau/dop/adapters/logger/zap"
+	"github.com/rendau/dop/dopErrs"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage(t *testing.T) *St {
+	fakeHandler := gofakes3.New(s3mem.New()).Server()
+
+	// gofakes3 treats an empty "delimiter" parameter as "/", strip it for recursive listings
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query(); q.Has("delimiter") && q.Get("delimiter") == "" {
+			q.Del("delimiter")
+			r.URL.RawQuery = q.Encode()
+		}
+		fakeHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	srvUrl, err := url.Parse(srv.URL)
+	require.Nil(t, err)
+
+	s, err := New(dopLoggerZap.New("info", true), OptionsSt{
+		Endpoint:  srvUrl.Host,
+		AccessKey: "test",
+		SecretKey: "test",
+		Region:    "us-east-1",
+		Bucket:    "kazan",
+		Prefix:    "files",
+	})
+	require.Nil(t, err)
+
+	err = s.EnsureBucket()
+	require.Nil(t, err)
+
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	s := newTestStorage(t)
+
+	err := s.Put("photos/2024/05/01/a.txt", bytes.NewBufferString("a content"))
+	require.Nil(t, err)
+
+	err = s.Put("photos/2024/05/01/zip_q/index.html", bytes.NewBufferString("html"))
+	require.Nil(t, err)
+
+	err = s.Put("photos/2024/05/01/zip_q/css/a.css", bytes.NewBufferString("css"))
+	require.Nil(t, err)
+
+	r, err := s.Get("/photos/2024/05/01/a.txt")
+	require.Nil(t, err)
+	data, err := io.ReadAll(r)
+	require.Nil(t, err)
+	require.Nil(t, r.Close())
+	require.Equal(t, "a content", string(data))
+
+	_, err = s.Get("photos/2024/05/01/b.txt")
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	fInfo, err := s.Stat("photos/2024/05/01/a.txt")
+	require.Nil(t, err)
+	require.False(t, fInfo.IsDir)
+	require.Equal(t, int64(9), fInfo.Size)
+
+	fInfo, err = s.Stat("photos/2024/05/01/zip_q")
+	require.Nil(t, err)
+	require.True(t, fInfo.IsDir)
+	require.Equal(t, "zip_q", fInfo.Name)
+	require.True(t, fInfo.ModTime.IsZero())
+
+	fInfo, err = s.Stat("photos")
+	require.Nil(t, err)
+	require.True(t, fInfo.IsDir)
+
+	_, err = s.Stat("photos/2024/05/02")
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	items, err := s.List("photos/2024/05/01")
+	require.Nil(t, err)
+	require.Len(t, items, 2)
+	require.Equal(t, "a.txt", items[0].Name)
+	require.False(t, items[0].IsDir)
+	require.Equal(t, "zip_q", items[1].Name)
+	require.True(t, items[1].IsDir)
+
+	err = s.Rename("photos/2024/05/01/zip_q", "photos/2024/05/01/zip_w")
+	require.Nil(t, err)
+
+	_, err = s.Stat("photos/2024/05/01/zip_q")
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	fInfo, err = s.Stat("photos/2024/05/01/zip_w/css/a.css")
+	require.Nil(t, err)
+	require.Equal(t, int64(3), fInfo.Size)
+
+	err = s.Remove("photos/2024/05/01/zip_w")
+	require.Nil(t, err)
+
+	err = s.Remove("photos/2024/05/01/a.txt")
+	require.Nil(t, err)
+
+	_, err = s.Stat("photos")
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	err = s.Remove("/")
+	require.Equal(t, dopErrs.PermissionDenied, err)
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"io"
+	"time"
+)
+
+type Storage interface {
+	Put(p string, r io.Reader) error
+	Get(p string) (io.ReadSeekCloser, error)
+	Stat(p string) (*FileInfoSt, error)
+	List(dirPath string) ([]*FileInfoSt, error)
+	Remove(p string) error
+	Rename(oldPath, newPath string) error
+}
+
+type FileInfoSt struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
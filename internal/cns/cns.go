@@ -1,3 +1,33 @@
 package cns
 
-const ()
+import (
+	"time"
+)
+
+const (
+	DedupDirName = ".dedup"
+	MetaDirName  = ".meta"
+
+	DerivativeDirName = ".derivative"
+
+	UploadDirName       = ".upload"
+	UploadCleanInterval = 10 * time.Minute
+)
+
+const (
+	ImgQuality = 90
+	ImgMaxDpr  = 4
+
+	// ImgMaxSigma limits sharpen and blur, size of their kernel grows with sigma
+	ImgMaxSigma = 50
+
+	// ImgBusyRetryAfter is suggested to clients when all decode slots are taken
+	ImgBusyRetryAfter = 2 * time.Second
+)
+
+// ImgSrcsetDprs are pixel ratios of srcset candidates for a preset
+var ImgSrcsetDprs = []float64{1, 2, 3}
+
+const (
+	CacheDuration = 30 * time.Minute
+)
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+// BlurHash encoder, see https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+
+const blurHashChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encodeBlurHash returns BlurHash of the image with xComps*yComps components (1-9 each)
+func encodeBlurHash(img *image.NRGBA, xComps int, yComps int) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	// linear values of pixels are computed once
+	linear := make([][3]float64, w*h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(img.Bounds().Min.X+x, img.Bounds().Min.Y+y)
+			linear[y*w+x] = [3]float64{
+				blurHashSRGBToLinear(img.Pix[i]),
+				blurHashSRGBToLinear(img.Pix[i+1]),
+				blurHashSRGBToLinear(img.Pix[i+2]),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComps*yComps)
+
+	for j := 0; j < yComps; j++ {
+		for i := 0; i < xComps; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var f [3]float64
+
+			for y := 0; y < h; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(h))
+
+				for x := 0; x < w; x++ {
+					basis := normalisation * basisY * math.Cos(math.Pi*float64(i)*float64(x)/float64(w))
+					px := linear[y*w+x]
+
+					f[0] += basis * px[0]
+					f[1] += basis * px[1]
+					f[2] += basis * px[2]
+				}
+			}
+
+			scale := 1 / float64(w*h)
+
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	sb := &strings.Builder{}
+
+	blurHashEncode83(sb, (xComps-1)+(yComps-1)*9, 1)
+
+	maxValue := 1.0
+
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, f := range factors[1:] {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+
+		blurHashEncode83(sb, quantisedMax, 1)
+	} else {
+		blurHashEncode83(sb, 0, 1)
+	}
+
+	dc := factors[0]
+	blurHashEncode83(sb, blurHashLinearToSRGB(dc[0])<<16+blurHashLinearToSRGB(dc[1])<<8+blurHashLinearToSRGB(dc[2]), 4)
+
+	for _, f := range factors[1:] {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(blurHashSignPow(v/maxValue, 0.5)*9+9.5))))
+		}
+
+		blurHashEncode83(sb, quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2)
+	}
+
+	return sb.String()
+}
+
+func blurHashEncode83(sb *strings.Builder, value int, length int) {
+	divisor := 1
+	for i := 1; i < length; i++ {
+		divisor *= 83
+	}
+
+	for ; divisor > 0; divisor /= 83 {
+		sb.WriteByte(blurHashChars[(value/divisor)%83])
+	}
+}
+
+func blurHashSRGBToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func blurHashLinearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func blurHashSignPow(v float64, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"container/list"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rendau/kazan/internal/cns"
+	"github.com/rendau/kazan/internal/domain/types"
+)
+
+// Cache pools, raw files and image derivatives do not evict each other
+const (
+	CachePoolRaw  = "raw"
+	CachePoolImg  = "img"
+	CachePoolDisk = "disk"
+)
+
+// CacheOptionsSt is memory budget of cache, zero size disables the pool.
+// Objects bigger than MaxObjectSize are not cached.
+// DiskSize is quota of derivatives kept in storage, zero disables them.
+type CacheOptionsSt struct {
+	RawSize       int64
+	ImgSize       int64
+	MaxObjectSize int64
+	DiskSize      int64
+}
+
+type cacheItemSt struct {
+	key       string
+	name      string
+	modTime   time.Time
+	content   []byte
+	meta      *types.StaticMetaSt
+	expiresAt time.Time
+}
+
+// cachePoolSt is LRU list of items limited by total size of their content
+type cachePoolSt struct {
+	budget int64
+	size   int64
+
+	items map[string]*list.Element
+	lru   *list.List // front is the most recently used
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// cacheFlightSt is a running call of Coalesce, waiters get its result when wg is done
+type cacheFlightSt struct {
+	wg   sync.WaitGroup
+	item *cacheItemSt
+	err  error
+}
+
+type Cache struct {
+	r *St
+
+	maxObjectSize int64
+	pools         map[string]*cachePoolSt
+	mu            sync.Mutex
+
+	flights   map[string]*cacheFlightSt
+	flightsMu sync.Mutex
+}
+
+func NewCache(r *St) *Cache {
+	c := &Cache{
+		r:             r,
+		maxObjectSize: r.cacheOpts.MaxObjectSize,
+		pools:         map[string]*cachePoolSt{},
+		flights:       map[string]*cacheFlightSt{},
+	}
+
+	for pool, budget := range map[string]int64{
+		CachePoolRaw: r.cacheOpts.RawSize,
+		CachePoolImg: r.cacheOpts.ImgSize,
+	} {
+		c.pools[pool] = &cachePoolSt{
+			budget: budget,
+			items:  map[string]*list.Element{},
+			lru:    list.New(),
+		}
+	}
+
+	return c
+}
+
+func (c *Cache) GenerateKey(reqPath string, imgPars *types.ImgParsSt, download bool) string {
+	result := c.normPath(reqPath)
+
+	// directory with trailing slash is served as its index.html, without it is not found
+	if result != "" && strings.HasSuffix(reqPath, "/") {
+		result += "/"
+	}
+
+	result += "?" + imgPars.String()
+
+	if download {
+		result += "&download"
+	}
+
+	return result
+}
+
+// Fits checks if object of the size can be cached in the pool
+func (c *Cache) Fits(pool string, size int64) bool {
+	p := c.pools[pool]
+
+	return p.budget > 0 && size <= p.budget && (c.maxObjectSize <= 0 || size <= c.maxObjectSize)
+}
+
+// GetAndRefresh returns nil if there is no entry
+func (c *Cache) GetAndRefresh(pool string, key string) *cacheItemSt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.pools[pool]
+
+	el, ok := p.items[key]
+	if !ok {
+		p.misses++
+		return nil
+	}
+
+	item := el.Value.(*cacheItemSt)
+
+	now := time.Now()
+
+	if now.After(item.expiresAt) {
+		p.remove(el)
+		p.misses++
+		return nil
+	}
+
+	item.expiresAt = now.Add(cns.CacheDuration)
+	p.lru.MoveToFront(el)
+	p.hits++
+
+	return item
+}
+
+func (c *Cache) Set(pool string, key string, name string, modTime time.Time, content []byte, meta *types.StaticMetaSt) {
+	if !c.Fits(pool, int64(len(content))) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.pools[pool]
+
+	if el, ok := p.items[key]; ok {
+		p.remove(el)
+	}
+
+	item := &cacheItemSt{
+		key:       key,
+		name:      name,
+		modTime:   modTime,
+		content:   content,
+		meta:      meta,
+		expiresAt: time.Now().Add(cns.CacheDuration),
+	}
+
+	p.items[key] = p.lru.PushFront(item)
+	p.size += int64(len(content))
+
+	// least recently used ones are evicted
+	for p.size > p.budget {
+		p.remove(p.lru.Back())
+		p.evictions++
+	}
+}
+
+// Coalesce runs only one fn for the key at a time,
+// concurrent callers with the same key wait for it and get the same item and error
+func (c *Cache) Coalesce(key string, fn func() (*cacheItemSt, error)) (*cacheItemSt, error) {
+	c.flightsMu.Lock()
+
+	if flight, ok := c.flights[key]; ok {
+		c.flightsMu.Unlock()
+		flight.wg.Wait()
+		return flight.item, flight.err
+	}
+
+	flight := &cacheFlightSt{}
+	flight.wg.Add(1)
+	c.flights[key] = flight
+
+	c.flightsMu.Unlock()
+
+	completed := false
+
+	defer func() {
+		// fn panicked, waiters must not take empty result as a success
+		if !completed {
+			flight.err = errors.New("coalesced call failed")
+		}
+
+		c.flightsMu.Lock()
+		delete(c.flights, key)
+		c.flightsMu.Unlock()
+
+		flight.wg.Done()
+	}()
+
+	flight.item, flight.err = fn()
+	completed = true
+
+	return flight.item, flight.err
+}
+
+// RemoveForPath removes all entries of the file or of the files inside the directory
+func (c *Cache) RemoveForPath(p string) {
+	p = c.normPath(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, pool := range c.pools {
+		for key, el := range pool.items {
+			keyPath, _, _ := strings.Cut(key, "?")
+
+			if keyPath == p || strings.HasPrefix(keyPath, p+"/") {
+				pool.remove(el)
+			}
+		}
+	}
+}
+
+// Stats returns counters of all pools, including derivatives kept in storage
+func (c *Cache) Stats() map[string]*types.CacheStatsSt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make(map[string]*types.CacheStatsSt, len(c.pools)+1)
+
+	result[CachePoolDisk] = c.r.Derivative.Stats()
+
+	for name, p := range c.pools {
+		result[name] = &types.CacheStatsSt{
+			Budget:    p.budget,
+			Size:      p.size,
+			Count:     len(p.items),
+			Hits:      p.hits,
+			Misses:    p.misses,
+			Evictions: p.evictions,
+		}
+	}
+
+	return result
+}
+
+func (c *Cache) normPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+func (p *cachePoolSt) remove(el *list.Element) {
+	item := p.lru.Remove(el).(*cacheItemSt)
+
+	delete(p.items, item.key)
+	p.size -= int64(len(item.content))
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rendau/dop/dopErrs"
+
+	"github.com/rendau/kazan/internal/adapters/storage"
+	"github.com/rendau/kazan/internal/cns"
+)
+
+const (
+	dedupLinkPrefix = "kazan-blob:sha256:"
+	dedupLinkSize   = int64(len(dedupLinkPrefix) + sha256.Size*2)
+)
+
+var dedupHashRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Dedup stores uploads once in content-addressed area.
+// File at url-path becomes a small link to the blob, and every link has its own ref-file,
+// so blob is removed only when there are no refs left.
+type Dedup struct {
+	r *St
+
+	mu sync.Mutex
+}
+
+func NewDedup(r *St) *Dedup {
+	return &Dedup{
+		r: r,
+	}
+}
+
+// Create stores content as blob and links filePath to it, returns sha256 of the content
+func (c *Dedup) Create(filePath string, src io.Reader, noCut bool, stripMeta bool) (string, error) {
+	tmpPath, err := c.r.Static.newName(path.Join(cns.DedupDirName, "tmp"), "", path.Ext(filePath))
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := c.r.Static.putFile(tmpPath, src, noCut, stripMeta)
+	if err != nil {
+		_ = c.r.storage.Remove(tmpPath)
+		return "", err
+	}
+
+	err = c.link(tmpPath, filePath, hash)
+	if err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+func (c *Dedup) link(tmpPath string, filePath string, hash string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	blobPath := c.blobPath(hash)
+
+	_, err := c.r.storage.Stat(blobPath)
+	switch err {
+	case nil:
+		err = c.r.storage.Remove(tmpPath)
+	case dopErrs.ObjectNotFound:
+		err = c.r.storage.Rename(tmpPath, blobPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = c.r.storage.Put(c.refPath(hash, filePath), bytes.NewReader(nil))
+	if err != nil {
+		return err
+	}
+
+	return c.r.storage.Put(filePath, strings.NewReader(dedupLinkPrefix+hash))
+}
+
+// Resolve returns path and info of the blob if file is a link, otherwise returns them as is
+func (c *Dedup) Resolve(filePath string, fInfo *storage.FileInfoSt) (string, *storage.FileInfoSt, error) {
+	hash := c.linkHash(filePath, fInfo)
+	if hash == "" {
+		return filePath, fInfo, nil
+	}
+
+	blobPath := c.blobPath(hash)
+
+	blobInfo, err := c.r.storage.Stat(blobPath)
+	if err != nil {
+		if err == dopErrs.ObjectNotFound {
+			c.r.lg.Errorw("Blob not found for link", err, "f_path", filePath, "hash", hash)
+		}
+		return "", nil, err
+	}
+
+	return blobPath, blobInfo, nil
+}
+
+// Remove removes the link and reclaims the blob if nothing points at it any more.
+// Returns false if file is not a link.
+func (c *Dedup) Remove(filePath string, fInfo *storage.FileInfoSt) (bool, error) {
+	hash := c.linkHash(filePath, fInfo)
+	if hash == "" {
+		return false, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.r.storage.Remove(filePath)
+	if err != nil {
+		return true, err
+	}
+
+	err = c.r.storage.Remove(c.refPath(hash, filePath))
+	if err != nil && err != dopErrs.ObjectNotFound {
+		return true, err
+	}
+
+	refsDirPath := c.refsDirPath(hash)
+
+	refs, err := c.r.storage.List(refsDirPath)
+	if err != nil && err != dopErrs.ObjectNotFound {
+		return true, err
+	}
+
+	if len(refs) > 0 {
+		return true, nil
+	}
+
+	if err == nil {
+		_ = c.r.storage.Remove(refsDirPath)
+	}
+
+	err = c.r.storage.Remove(c.blobPath(hash))
+	if err != nil && err != dopErrs.ObjectNotFound {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// linkHash returns hash of the blob if file is a link.
+// Anyone can upload content looking like a link, so only links having ref record are trusted.
+// Links are resolved even if dedup is turned off, it affects only new uploads.
+func (c *Dedup) linkHash(filePath string, fInfo *storage.FileInfoSt) string {
+	if fInfo.IsDir || fInfo.Size != dedupLinkSize {
+		return ""
+	}
+
+	fReader, err := c.r.storage.Get(filePath)
+	if err != nil {
+		return ""
+	}
+	defer fReader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(fReader, dedupLinkSize))
+	if err != nil {
+		return ""
+	}
+
+	if !bytes.HasPrefix(data, []byte(dedupLinkPrefix)) {
+		return ""
+	}
+
+	hash := string(data[len(dedupLinkPrefix):])
+
+	if !dedupHashRe.MatchString(hash) {
+		return ""
+	}
+
+	_, err = c.r.storage.Stat(c.refPath(hash, filePath))
+	if err != nil {
+		return ""
+	}
+
+	return hash
+}
+
+func (c *Dedup) blobPath(hash string) string {
+	return path.Join(cns.DedupDirName, "blobs", hash[:2], hash)
+}
+
+func (c *Dedup) refsDirPath(hash string) string {
+	return path.Join(cns.DedupDirName, "refs", hash)
+}
+
+func (c *Dedup) refPath(hash string, filePath string) string {
+	return path.Join(c.refsDirPath(hash), url.PathEscape(filePath))
+}
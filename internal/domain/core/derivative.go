@@ -0,0 +1,325 @@
+package core
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rendau/dop/dopErrs"
+
+	"github.com/rendau/kazan/internal/cns"
+	"github.com/rendau/kazan/internal/domain/types"
+)
+
+// Derivative keeps transformed images in storage, so they survive restarts.
+// Every source file has its own directory of derivatives, named by hash of image params and server options.
+// Size of all derivatives is limited, least recently used ones are removed first.
+// Recency is tracked in memory, after restart it is restored from modification time of derivatives.
+type Derivative struct {
+	r *St
+
+	budget int64
+	size   int64
+
+	items map[string]*list.Element
+	lru   *list.List // front is the most recently used
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	mu sync.Mutex
+}
+
+type derivativeItemSt struct {
+	path      string
+	size      int64
+	createdAt time.Time
+}
+
+func NewDerivative(r *St) *Derivative {
+	return &Derivative{
+		r:      r,
+		budget: r.cacheOpts.DiskSize,
+		items:  map[string]*list.Element{},
+		lru:    list.New(),
+	}
+}
+
+// Load builds index of derivatives kept in storage, it is called once on start
+func (c *Derivative) Load() error {
+	if c.budget <= 0 {
+		return nil
+	}
+
+	items := make([]*derivativeItemSt, 0)
+
+	err := c.walk(cns.DerivativeDirName, func(item *derivativeItemSt) {
+		items = append(items, item)
+	})
+	if err != nil {
+		c.r.lg.Errorw("Fail to load derivatives", err)
+		return err
+	}
+
+	// the oldest ones are pushed to the back
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].createdAt.After(items[j].createdAt)
+	})
+
+	c.mu.Lock()
+
+	for _, item := range items {
+		if _, ok := c.items[item.path]; ok {
+			continue
+		}
+
+		c.items[item.path] = c.lru.PushBack(item)
+		c.size += item.size
+	}
+
+	victims := c.evict()
+
+	c.mu.Unlock()
+
+	c.removeFiles(victims)
+
+	return nil
+}
+
+// Get returns nil if there is no actual derivative, derivative is outdated if the source was modified after it
+func (c *Derivative) Get(srcPath string, srcModTime time.Time, pars *types.ImgParsSt) []byte {
+	if c.budget <= 0 {
+		return nil
+	}
+
+	p := c.itemPath(srcPath, pars)
+
+	c.mu.Lock()
+
+	el, ok := c.items[p]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil
+	}
+
+	if el.Value.(*derivativeItemSt).createdAt.Before(srcModTime) {
+		c.remove(el)
+		c.misses++
+		c.mu.Unlock()
+		c.removeFiles([]string{p})
+		return nil
+	}
+
+	c.lru.MoveToFront(el)
+
+	c.mu.Unlock()
+
+	data, err := c.read(p)
+	if err != nil {
+		c.mu.Lock()
+		if el, ok = c.items[p]; ok {
+			c.remove(el)
+		}
+		c.misses++
+		c.mu.Unlock()
+		return nil
+	}
+
+	c.mu.Lock()
+	c.hits++
+	c.mu.Unlock()
+
+	return data
+}
+
+// Set saves derivative, errors are only logged, because derivative can always be made again
+func (c *Derivative) Set(srcPath string, pars *types.ImgParsSt, data []byte) {
+	size := int64(len(data))
+
+	if c.budget <= 0 || size > c.budget {
+		return
+	}
+
+	p := c.itemPath(srcPath, pars)
+
+	err := c.r.storage.Put(p, bytes.NewReader(data))
+	if err != nil {
+		c.r.lg.Errorw("Fail to save derivative", err, "path", p)
+		return
+	}
+
+	c.mu.Lock()
+
+	if el, ok := c.items[p]; ok {
+		c.remove(el)
+	}
+
+	c.items[p] = c.lru.PushFront(&derivativeItemSt{
+		path:      p,
+		size:      size,
+		createdAt: time.Now(),
+	})
+	c.size += size
+
+	victims := c.evict()
+
+	c.mu.Unlock()
+
+	c.removeFiles(victims)
+}
+
+// RemoveForPath removes derivatives of the file or of the files inside the directory
+func (c *Derivative) RemoveForPath(srcPath string) {
+	dirPath := c.dirPath(srcPath)
+
+	c.mu.Lock()
+
+	for p, el := range c.items {
+		if strings.HasPrefix(p, dirPath+"/") {
+			c.remove(el)
+		}
+	}
+
+	c.mu.Unlock()
+
+	err := c.r.storage.Remove(dirPath)
+	if err != nil && err != dopErrs.ObjectNotFound {
+		c.r.lg.Errorw("Fail to remove derivatives", err, "path", dirPath)
+		return
+	}
+
+	c.r.Static.pruneDateDirs(path.Dir(dirPath))
+}
+
+func (c *Derivative) Stats() *types.CacheStatsSt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &types.CacheStatsSt{
+		Budget:    c.budget,
+		Size:      c.size,
+		Count:     len(c.items),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+func (c *Derivative) read(p string) ([]byte, error) {
+	fReader, err := c.r.storage.Get(p)
+	if err != nil {
+		if err != dopErrs.ObjectNotFound {
+			c.r.lg.Errorw("Fail to open derivative", err, "path", p)
+		}
+		return nil, err
+	}
+	defer fReader.Close()
+
+	data, err := io.ReadAll(fReader)
+	if err != nil {
+		c.r.lg.Errorw("Fail to read derivative", err, "path", p)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (c *Derivative) walk(dirPath string, cb func(item *derivativeItemSt)) error {
+	items, err := c.r.storage.List(dirPath)
+	if err != nil {
+		if err == dopErrs.ObjectNotFound {
+			return nil
+		}
+		return err
+	}
+
+	for _, item := range items {
+		p := path.Join(dirPath, item.Name)
+
+		if item.IsDir {
+			err = c.walk(p, cb)
+			if err != nil {
+				return err
+			}
+		} else if c.isItemName(item.Name) {
+			cb(&derivativeItemSt{
+				path:      p,
+				size:      item.Size,
+				createdAt: item.ModTime,
+			})
+		}
+	}
+
+	return nil
+}
+
+// evict removes items from index while it is over budget, returns paths of removed ones
+func (c *Derivative) evict() []string {
+	var result []string
+
+	for c.size > c.budget {
+		el := c.lru.Back()
+
+		result = append(result, el.Value.(*derivativeItemSt).path)
+
+		c.remove(el)
+		c.evictions++
+	}
+
+	return result
+}
+
+func (c *Derivative) remove(el *list.Element) {
+	item := c.lru.Remove(el).(*derivativeItemSt)
+
+	delete(c.items, item.path)
+	c.size -= item.size
+}
+
+func (c *Derivative) removeFiles(paths []string) {
+	for _, p := range paths {
+		err := c.r.storage.Remove(p)
+		if err != nil && err != dopErrs.ObjectNotFound {
+			c.r.lg.Errorw("Fail to remove derivative", err, "path", p)
+			continue
+		}
+
+		dirPath := path.Dir(p)
+
+		items, err := c.r.storage.List(dirPath)
+		if err == nil && len(items) == 0 {
+			_ = c.r.storage.Remove(dirPath)
+			c.r.Static.pruneDateDirs(path.Dir(dirPath))
+		}
+	}
+}
+
+func (c *Derivative) dirPath(srcPath string) string {
+	return path.Join(cns.DerivativeDirName, strings.Trim(path.Clean("/"+srcPath), "/"))
+}
+
+// itemPath depends on options of the server too, so derivatives made before their change are not served
+func (c *Derivative) itemPath(srcPath string, pars *types.ImgParsSt) string {
+	hash := sha256.Sum256([]byte(pars.String() + "&" + c.r.Img.Fingerprint()))
+
+	return path.Join(c.dirPath(srcPath), hex.EncodeToString(hash[:]))
+}
+
+func (c *Derivative) isItemName(v string) bool {
+	if len(v) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(v)
+
+	return err == nil
+}
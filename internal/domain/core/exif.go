@@ -0,0 +1,255 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+
+	"github.com/rendau/kazan/internal/domain/errs"
+	"github.com/rendau/kazan/internal/domain/types"
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+	// imgStripFormats are formats metadata is stripped from
+	imgStripFormats = map[string]bool{"jpeg": true, "png": true, "tiff": true}
+
+	// jpegMetaMarkers are segments to drop: APP1 (EXIF, XMP), APP13 (IPTC), COM
+	jpegMetaMarkers = map[byte]bool{0xe1: true, 0xed: true, 0xfe: true}
+
+	// pngMetaChunks are chunks to drop, XMP and IPTC are stored in text chunks
+	pngMetaChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+)
+
+// StripMeta writes copy of the image without EXIF, XMP and IPTC.
+// Orientation from EXIF is applied to pixels first, then the image is re-encoded,
+// otherwise metadata is cut out without touching the pixels.
+// Format is taken from the content, extension may lie about it.
+// Nothing is written if there is nothing to strip.
+func (c *Img) StripMeta(fName string, src io.Reader, w io.Writer) error {
+	if !imgStripFormats[imgFileTypes[strings.ToLower(filepath.Ext(fName))]] {
+		return nil
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+
+	cfg, srcFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return errs.BadFile
+	}
+
+	if !imgStripFormats[srcFormat] {
+		return nil
+	}
+
+	orientation := imgExifOrientation(srcFormat, data)
+
+	// tiff keeps metadata in the same directory with image structure, so it is always re-encoded
+	if orientation > 1 || srcFormat == "tiff" {
+		err = c.checkPixels(cfg.Width, cfg.Height)
+		if err != nil {
+			return err
+		}
+
+		img, err := imaging.Decode(bytes.NewReader(data))
+		if err != nil {
+			return errs.BadFile
+		}
+
+		err = imgFormats[srcFormat].encode(w, imgOrient(img, orientation), c.encodeOpts(&types.ImgParsSt{}))
+		if err != nil {
+			c.r.lg.Errorw("Fail to encode image", err)
+			return err
+		}
+
+		return nil
+	}
+
+	var result []byte
+
+	if srcFormat == "jpeg" {
+		result, err = jpegStripMeta(data)
+	} else {
+		result, err = pngStripMeta(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(result) == len(data) {
+		return nil
+	}
+
+	_, err = w.Write(result)
+
+	return err
+}
+
+// imgExifOrientation returns value of EXIF orientation tag, 1 (normal) if it is not set
+func imgExifOrientation(srcFormat string, data []byte) int {
+	exifData := data
+
+	if srcFormat == "png" {
+		exifData = nil
+
+		_ = pngChunks(data, func(chunkType string, chunk []byte) {
+			if chunkType == "eXIf" {
+				exifData = chunk[8 : len(chunk)-4]
+			}
+		})
+
+		if exifData == nil {
+			return 1
+		}
+	}
+
+	x, err := exif.Decode(bytes.NewReader(exifData))
+	if err != nil {
+		return 1
+	}
+
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+
+	v, err := tag.Int(0)
+	if err != nil || v < 1 || v > 8 {
+		return 1
+	}
+
+	return v
+}
+
+// imgOrient transforms image as EXIF orientation says, so it can be shown as is
+func imgOrient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+
+	return img
+}
+
+// jpegStripMeta cuts metadata segments out of jpeg, everything from the start of scan is copied as is
+func jpegStripMeta(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errs.BadFile
+	}
+
+	result := make([]byte, 0, len(data))
+	result = append(result, data[:2]...)
+
+	pos := 2
+
+	for {
+		if pos+2 > len(data) || data[pos] != 0xff {
+			return nil, errs.BadFile
+		}
+
+		marker := data[pos+1]
+
+		// fill bytes
+		if marker == 0xff {
+			pos++
+			continue
+		}
+
+		// markers without payload
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			result = append(result, data[pos:pos+2]...)
+			pos += 2
+			continue
+		}
+
+		if marker == 0xda || marker == 0xd9 {
+			return append(result, data[pos:]...), nil
+		}
+
+		if pos+4 > len(data) {
+			return nil, errs.BadFile
+		}
+
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			return nil, errs.BadFile
+		}
+
+		if !jpegMetaMarkers[marker] {
+			result = append(result, data[pos:end]...)
+		}
+
+		pos = end
+	}
+}
+
+// pngStripMeta drops metadata chunks from png
+func pngStripMeta(data []byte) ([]byte, error) {
+	result := make([]byte, 0, len(data))
+	result = append(result, pngSignature...)
+
+	err := pngChunks(data, func(chunkType string, chunk []byte) {
+		if !pngMetaChunks[chunkType] {
+			result = append(result, chunk...)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// pngChunks calls cb for every chunk of png, chunk includes length, type and crc
+func pngChunks(data []byte, cb func(chunkType string, chunk []byte)) error {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return errs.BadFile
+	}
+
+	pos := len(pngSignature)
+
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return errs.BadFile
+		}
+
+		end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+		if end > len(data) || end < pos {
+			return errs.BadFile
+		}
+
+		chunkType := string(data[pos+4 : pos+8])
+
+		cb(chunkType, data[pos:end])
+
+		pos = end
+
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return nil
+}
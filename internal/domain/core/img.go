@@ -1,52 +1,667 @@
 package core
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
 	"io"
+	"math"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/chai2010/webp"
 	"github.com/disintegration/imaging"
+	"github.com/gen2brain/avif"
+	"github.com/rwcarlsen/goexif/exif"
 
+	"github.com/rendau/kazan/internal/cns"
+	"github.com/rendau/kazan/internal/domain/errs"
 	"github.com/rendau/kazan/internal/domain/types"
 )
 
 var (
-	imgFileTypes = map[string]struct {
-		format      imaging.Format
+	// imgFileTypes maps extensions of source images to their format
+	imgFileTypes = map[string]string{
+		".jpg":  "jpeg",
+		".jpeg": "jpeg",
+		".png":  "png",
+		".tif":  "tiff",
+		".tiff": "tiff",
+		".bmp":  "bmp",
+		".webp": "webp",
+		".avif": "avif",
+		".gif":  "gif",
+	}
+
+	// imgFormats is the list of formats images can be encoded into
+	imgFormats = map[string]struct {
+		ext         string
 		contentType string
+		encode      func(w io.Writer, img image.Image, opts *imgEncodeOptsSt) error
 	}{
-		".jpg":  {imaging.JPEG, "image/jpeg"},
-		".jpeg": {imaging.JPEG, "image/jpeg"},
-		".png":  {imaging.PNG, "image/png"},
-		".tif":  {imaging.TIFF, "image/tiff"},
-		".tiff": {imaging.TIFF, "image/tiff"},
-		".bmp":  {imaging.BMP, "image/bmp"},
-		// ".gif":  {imaging.GIF, "image/gif"},
+		"jpeg": {".jpg", "image/jpeg", func(w io.Writer, img image.Image, opts *imgEncodeOptsSt) error {
+			return imaging.Encode(w, img, imaging.JPEG, imaging.JPEGQuality(opts.quality))
+		}},
+		"png": {".png", "image/png", func(w io.Writer, img image.Image, opts *imgEncodeOptsSt) error {
+			return imaging.Encode(w, img, imaging.PNG, imaging.PNGCompressionLevel(opts.pngCompression))
+		}},
+		"tiff": {".tif", "image/tiff", imagingEncoder(imaging.TIFF)},
+		"bmp":  {".bmp", "image/bmp", imagingEncoder(imaging.BMP)},
+		"gif":  {".gif", "image/gif", imagingEncoder(imaging.GIF)},
+		"webp": {".webp", "image/webp", func(w io.Writer, img image.Image, opts *imgEncodeOptsSt) error {
+			return webp.Encode(w, img, &webp.Options{Quality: float32(opts.quality)})
+		}},
+		"avif": {".avif", "image/avif", func(w io.Writer, img image.Image, opts *imgEncodeOptsSt) error {
+			return avif.Encode(w, img, avif.Options{
+				Quality:           opts.quality,
+				QualityAlpha:      opts.quality,
+				Speed:             avif.DefaultSpeed,
+				ChromaSubsampling: image.YCbCrSubsampleRatio420,
+			})
+		}},
+	}
+
+	// imgAutoFormats is the order of preference for f=auto, first one accepted by client wins
+	imgAutoFormats = []string{"avif", "webp"}
+
+	// imgMethods are methods of resizing, empty one is fill
+	imgMethods = map[string]bool{"": true, "fill": true, "fit": true, "crop": true}
+
+	imgGravities = map[string]imaging.Anchor{
+		"":             imaging.Center,
+		"center":       imaging.Center,
+		"top":          imaging.Top,
+		"bottom":       imaging.Bottom,
+		"left":         imaging.Left,
+		"right":        imaging.Right,
+		"top_left":     imaging.TopLeft,
+		"top_right":    imaging.TopRight,
+		"bottom_left":  imaging.BottomLeft,
+		"bottom_right": imaging.BottomRight,
+	}
+
+	imgPngCompressions = map[string]png.CompressionLevel{
+		"default": png.DefaultCompression,
+		"no":      png.NoCompression,
+		"speed":   png.BestSpeed,
+		"best":    png.BestCompression,
+	}
+)
+
+// ImgOptionsSt is server-side defaults and limits for encoding of images
+type ImgOptionsSt struct {
+	Quality        int
+	QualityMin     int
+	QualityMax     int
+	PngCompression string
+
+	// Presets are named sets of parameters, in Strict mode only they are allowed
+	Presets map[string]*types.ImgParsSt
+	Strict  bool
+
+	// StripMeta is default for uploads: remove EXIF, XMP and IPTC from jpeg, png and tiff
+	StripMeta bool
+
+	// WMark is drawn over all derivatives of images in WMarkDirPaths,
+	// and over any image on request if WMarkOnDemand is set.
+	// Scale is a fraction of output width, 0 keeps the watermark in its own size.
+	WMark         image.Image
+	WMarkPosition string
+	WMarkOpacity  float64
+	WMarkScale    float64
+	WMarkMargin   int
+	WMarkDirPaths []string
+	WMarkOnDemand bool
+
+	// MaxPixels limits width*height of source images, header is checked before decoding.
+	// MaxFrames limits number of frames of gif, MaxAnimPixels limits size of its canvas times number of frames,
+	// because every frame is composed on the whole canvas.
+	// MaxOutWidth and MaxOutHeight limit requested w and h, and size of rotated image. Zero is no limit.
+	MaxPixels     int64
+	MaxFrames     int
+	MaxAnimPixels int64
+	MaxOutWidth   int
+	MaxOutHeight  int
+
+	// DecodeLimit is max number of images decoded at once for requests and uploads, zero is no limit.
+	// Caller waits for a free slot up to DecodeWait, then it fails with errs.ImgBusy.
+	DecodeLimit int
+	DecodeWait  time.Duration
+}
+
+type imgEncodeOptsSt struct {
+	quality        int
+	pngCompression png.CompressionLevel
+}
+
+func imagingEncoder(format imaging.Format) func(w io.Writer, img image.Image, opts *imgEncodeOptsSt) error {
+	return func(w io.Writer, img image.Image, opts *imgEncodeOptsSt) error {
+		return imaging.Encode(w, img, format)
 	}
+}
+
+const (
+	imgSmartCropThumbSize = 128.0
+	imgSmartCropSteps     = 16
+
+	imgDominantColorThumbSize = 64
+
+	imgBlurHashThumbSize = 64
+	imgBlurHashXComps    = 4
+	imgBlurHashYComps    = 3
+
+	imgLqipSize    = 16
+	imgLqipQuality = 50
 )
 
 type Img struct {
 	r *St
+
+	decodeSlots chan struct{}
+	fingerprint string
 }
 
 func NewImg(r *St) *Img {
-	return &Img{
+	c := &Img{
 		r: r,
 	}
+
+	if r.imgOpts.DecodeLimit > 0 {
+		c.decodeSlots = make(chan struct{}, r.imgOpts.DecodeLimit)
+	}
+
+	c.fingerprint = imgOptsFingerprint(&r.imgOpts)
+
+	return c
+}
+
+// Fingerprint returns hash of options changing output of transformations with the same params:
+// encoder defaults and watermark
+func (c *Img) Fingerprint() string {
+	return c.fingerprint
+}
+
+func imgOptsFingerprint(opts *ImgOptionsSt) string {
+	h := sha256.New()
+
+	_, _ = fmt.Fprintf(
+		h,
+		"q=%d&q_min=%d&q_max=%d&png_c=%s&wmark_pos=%s&wmark_opacity=%f&wmark_scale=%f&wmark_margin=%d",
+		opts.Quality, opts.QualityMin, opts.QualityMax, opts.PngCompression,
+		opts.WMarkPosition, opts.WMarkOpacity, opts.WMarkScale, opts.WMarkMargin,
+	)
+
+	if opts.WMark != nil {
+		mark := imaging.Clone(opts.WMark)
+
+		_, _ = fmt.Fprintf(h, "&wmark=%dx%d:", mark.Rect.Dx(), mark.Rect.Dy())
+		_, _ = h.Write(mark.Pix)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// acquireDecode takes a decode slot, returned func releases it
+func (c *Img) acquireDecode() (func(), error) {
+	if c.decodeSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case c.decodeSlots <- struct{}{}:
+	default:
+		if c.r.imgOpts.DecodeWait <= 0 {
+			return nil, errs.ImgBusy
+		}
+
+		timer := time.NewTimer(c.r.imgOpts.DecodeWait)
+		defer timer.Stop()
+
+		select {
+		case c.decodeSlots <- struct{}{}:
+		case <-timer.C:
+			return nil, errs.ImgBusy
+		}
+	}
+
+	return func() { <-c.decodeSlots }, nil
 }
 
-func (c *Img) Handle(fPath string, w io.Writer, pars *types.ImgParsSt) error {
+func (c *Img) Handle(fName string, src io.Reader, w io.Writer, pars *types.ImgParsSt) error {
 	if pars.IsEmpty() {
 		return nil
 	}
 
-	fileExt := strings.ToLower(filepath.Ext(fPath))
+	srcFormat, ok := imgFileTypes[strings.ToLower(filepath.Ext(fName))]
+	if !ok {
+		return nil
+	}
+
+	dstFormat := srcFormat
+	if pars.Format != "" {
+		dstFormat = pars.Format
+	}
+
+	imgFormat, ok := imgFormats[dstFormat]
+	if !ok {
+		return errs.BadImgFormat
+	}
+
+	hasChanges := dstFormat != srcFormat
+
+	img, anim, err := c.decodeImg(srcFormat, src)
+	if err != nil {
+		return err
+	}
+
+	if anim != nil {
+		if pars.Frame == nil && dstFormat == "gif" && len(anim.Image) > 1 {
+			return c.handleAnimation(anim, w, pars)
+		}
+
+		frameIdx := 0
+		if pars.Frame != nil {
+			frameIdx = *pars.Frame
+		}
+
+		img, err = gifFrame(anim, frameIdx)
+		if err != nil {
+			return err
+		}
+
+		if len(anim.Image) > 1 {
+			hasChanges = true
+		}
+	}
+
+	img, changed, err := c.transform(img, pars)
+	if err != nil {
+		return err
+	}
+	if changed {
+		hasChanges = true
+	}
+
+	if hasChanges {
+		err = imgFormat.encode(w, img, c.encodeOpts(pars))
+		if err != nil {
+			c.r.lg.Errorw("Fail to encode image", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeImg decodes image with EXIF orientation applied.
+// Gif is decoded with all frames into anim, then img is nil.
+// Size from the header is checked first, so pixels of too large image are never allocated.
+func (c *Img) decodeImg(srcFormat string, src io.Reader) (image.Image, *gif.GIF, error) {
+	header := new(bytes.Buffer)
+
+	cfg, _, err := image.DecodeConfig(io.TeeReader(src, header))
+	if err != nil {
+		return nil, nil, errs.NotImg
+	}
+
+	err = c.checkPixels(cfg.Width, cfg.Height)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	src = io.MultiReader(header, src)
+
+	if srcFormat == "gif" {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return nil, nil, errs.NotImg
+		}
+
+		// frames are counted before decoding, all of them are kept in memory
+		err = c.checkGifFrames(data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, errs.NotImg
+		}
+
+		return nil, anim, nil
+	}
+
+	img, err := imaging.Decode(src, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, nil, errs.NotImg
+	}
+
+	return img, nil, nil
+}
+
+// checkPixels checks size of source image against MaxPixels
+func (c *Img) checkPixels(width int, height int) error {
+	if c.r.imgOpts.MaxPixels > 0 && int64(width)*int64(height) > c.r.imgOpts.MaxPixels {
+		return errs.ImgTooLarge
+	}
+
+	return nil
+}
+
+// checkGifFrames walks blocks of gif and checks number of frames against MaxFrames
+// and size of canvas times number of frames against MaxAnimPixels
+func (c *Img) checkGifFrames(data []byte) error {
+	if len(data) < 13 {
+		return errs.NotImg
+	}
+
+	colorTableSize := func(flags byte) int {
+		if flags&0x80 == 0 {
+			return 0
+		}
+		return 3 << ((flags & 0x07) + 1)
+	}
+
+	// header and logical screen descriptor, canvas is the screen extended by frames out of it
+	canvasW := int64(data[6]) | int64(data[7])<<8
+	canvasH := int64(data[8]) | int64(data[9])<<8
+	pos := 13 + colorTableSize(data[10])
+
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := int64(0)
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension
+			pos += 2
+			if !skipSubBlocks() {
+				return errs.NotImg
+			}
+		case 0x2c: // image descriptor
+			if pos+10 > len(data) {
+				return errs.NotImg
+			}
+
+			left := int64(data[pos+1]) | int64(data[pos+2])<<8
+			top := int64(data[pos+3]) | int64(data[pos+4])<<8
+			width := int64(data[pos+5]) | int64(data[pos+6])<<8
+			height := int64(data[pos+7]) | int64(data[pos+8])<<8
+
+			if left+width > canvasW {
+				canvasW = left + width
+			}
+			if top+height > canvasH {
+				canvasH = top + height
+			}
+
+			frames++
+
+			if c.r.imgOpts.MaxFrames > 0 && frames > int64(c.r.imgOpts.MaxFrames) {
+				return errs.ImgTooLarge
+			}
+
+			if c.r.imgOpts.MaxAnimPixels > 0 && canvasW*canvasH*frames > c.r.imgOpts.MaxAnimPixels {
+				return errs.ImgTooLarge
+			}
+
+			// descriptor, local color table and lzw code size
+			pos += 10 + colorTableSize(data[pos+9]) + 1
+			if !skipSubBlocks() {
+				return errs.NotImg
+			}
+		case 0x3b: // trailer
+			return nil
+		default:
+			return errs.NotImg
+		}
+	}
+
+	return nil
+}
+
+// maxOutSize returns limits of output width and height, zero is no limit
+func (c *Img) maxOutSize() (int, int) {
+	minLimit := func(a, b int) int {
+		if a <= 0 || (b > 0 && b < a) {
+			return b
+		}
+		return a
+	}
+
+	return minLimit(c.r.imgMaxWidth, c.r.imgOpts.MaxOutWidth), minLimit(c.r.imgMaxHeight, c.r.imgOpts.MaxOutHeight)
+}
+
+// Info returns properties of the image, EXIF is read only from formats which carry it (jpeg, tiff)
+func (c *Img) Info(fName string, src io.ReadSeeker) (*types.ImgInfoSt, error) {
+	srcFormat, ok := imgFileTypes[strings.ToLower(filepath.Ext(fName))]
+	if !ok {
+		return nil, errs.NotImg
+	}
+
+	result := &types.ImgInfoSt{
+		Format: srcFormat,
+		Frames: 1,
+	}
+
+	cfg, _, err := image.DecodeConfig(src)
+	if err != nil {
+		return nil, errs.NotImg
+	}
+
+	result.ColorModel = imgColorModelName(cfg.ColorModel)
+
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	img, anim, err := c.decodeImg(srcFormat, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if anim != nil {
+		result.Frames = len(anim.Image)
+
+		img, err = gifFrame(anim, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result.Width = img.Bounds().Dx()
+	result.Height = img.Bounds().Dy()
+	result.DominantColor = imgDominantColor(img)
+
+	if srcFormat == "jpeg" || srcFormat == "tiff" {
+		_, err = src.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Exif = imgExif(src)
+	}
+
+	return result, nil
+}
 
-	imgFormat, ok := imgFileTypes[fileExt]
+// Placeholder returns BlurHash and tiny preview of the image for showing while the image loads
+func (c *Img) Placeholder(fName string, src io.Reader) (*types.ImgPlaceholderSt, error) {
+	srcFormat, ok := imgFileTypes[strings.ToLower(filepath.Ext(fName))]
 	if !ok {
+		return nil, errs.NotImg
+	}
+
+	img, anim, err := c.decodeImg(srcFormat, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if anim != nil {
+		img, err = gifFrame(anim, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &types.ImgPlaceholderSt{}
+
+	thumb := imaging.Fit(img, imgBlurHashThumbSize, imgBlurHashThumbSize, imaging.Box)
+
+	result.BlurHash = encodeBlurHash(thumb, imgBlurHashXComps, imgBlurHashYComps)
+
+	lqip := imaging.Fit(img, imgLqipSize, imgLqipSize, imaging.Lanczos)
+
+	lqipFormat := "jpeg"
+	if !lqip.Opaque() {
+		lqipFormat = "png"
+	}
+
+	buffer := new(bytes.Buffer)
+
+	err = imgFormats[lqipFormat].encode(buffer, lqip, &imgEncodeOptsSt{quality: imgLqipQuality, pngCompression: png.BestCompression})
+	if err != nil {
+		c.r.lg.Errorw("Fail to encode image", err)
+		return nil, err
+	}
+
+	result.Lqip = "data:" + imgFormats[lqipFormat].contentType + ";base64," + base64.StdEncoding.EncodeToString(buffer.Bytes())
+
+	return result, nil
+}
+
+// imgExif returns fields of EXIF we are interested in, nil if there is no EXIF
+func imgExif(src io.Reader) *types.ImgExifSt {
+	x, err := exif.Decode(src)
+	if err != nil {
 		return nil
 	}
 
+	result := &types.ImgExifSt{}
+
+	if v, err := x.DateTime(); err == nil {
+		result.CapturedAt = &v
+	}
+
+	if tag, err := x.Get(exif.Make); err == nil {
+		result.Make, _ = tag.StringVal()
+		result.Make = strings.TrimSpace(result.Make)
+	}
+
+	if tag, err := x.Get(exif.Model); err == nil {
+		result.Model, _ = tag.StringVal()
+		result.Model = strings.TrimSpace(result.Model)
+	}
+
+	if lat, lon, err := x.LatLong(); err == nil {
+		result.Lat, result.Lon = &lat, &lon
+	}
+
+	return result
+}
+
+func imgColorModelName(m color.Model) string {
+	switch m {
+	case color.RGBAModel:
+		return "rgba"
+	case color.RGBA64Model:
+		return "rgba64"
+	case color.NRGBAModel:
+		return "nrgba"
+	case color.NRGBA64Model:
+		return "nrgba64"
+	case color.AlphaModel:
+		return "alpha"
+	case color.Alpha16Model:
+		return "alpha16"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.NYCbCrAModel:
+		return "nycbcra"
+	case color.CMYKModel:
+		return "cmyk"
+	}
+
+	if _, ok := m.(color.Palette); ok {
+		return "paletted"
+	}
+
+	return "unknown"
+}
+
+// imgDominantColor returns the most frequent color (channels quantized to 4 bits) of the image in hex form,
+// transparent pixels are not counted
+func imgDominantColor(img image.Image) string {
+	thumb := imaging.Fit(img, imgDominantColorThumbSize, imgDominantColorThumbSize, imaging.Box)
+
+	type bucketSt struct {
+		n       int
+		r, g, b int
+	}
+
+	buckets := map[uint16]*bucketSt{}
+
+	var best *bucketSt
+
+	for i := 0; i+3 < len(thumb.Pix); i += 4 {
+		r, g, b, a := thumb.Pix[i], thumb.Pix[i+1], thumb.Pix[i+2], thumb.Pix[i+3]
+		if a < 128 {
+			continue
+		}
+
+		key := uint16(r>>4)<<8 | uint16(g>>4)<<4 | uint16(b>>4)
+
+		bucket := buckets[key]
+		if bucket == nil {
+			bucket = &bucketSt{}
+			buckets[key] = bucket
+		}
+
+		bucket.n++
+		bucket.r += int(r)
+		bucket.g += int(g)
+		bucket.b += int(b)
+
+		if best == nil || bucket.n > best.n {
+			best = bucket
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%02x%02x%02x", best.r/best.n, best.g/best.n, best.b/best.n)
+}
+
+// transform applies operations in this order: crop, rotate, flip, resize,
+// brightness, contrast, gamma, saturation, sharpen, blur, grayscale, invert, watermark.
+// Returns false if nothing was requested to change.
+func (c *Img) transform(img image.Image, pars *types.ImgParsSt) (image.Image, bool, error) {
 	pM := pars.Method
 	pW := pars.Width
 	pH := pars.Height
@@ -55,13 +670,57 @@ func (c *Img) Handle(fPath string, w io.Writer, pars *types.ImgParsSt) error {
 
 	hasChanges := false
 
-	img, err := imaging.Open(fPath, imaging.AutoOrientation(true))
-	if err != nil {
-		// c.lg.Errorw("Fail to open img", err)
-		return nil
+	imgBounds := img.Bounds().Size()
+
+	if pM == "crop" {
+		rect := image.Rect(pars.CropX, pars.CropY, pars.CropX+pars.CropW, pars.CropY+pars.CropH)
+		rect = rect.Add(img.Bounds().Min).Intersect(img.Bounds())
+		if rect.Empty() {
+			return nil, false, errs.BadImgParams
+		}
+
+		img = imaging.Crop(img, rect)
+		imgBounds = img.Bounds().Size()
+
+		// requested size is fitted into the region
+		pM = "fit"
+
+		hasChanges = true
+	}
+
+	if pars.Rotate != 0 {
+		bg, err := parseImgColor(pars.Background)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// rotated image must not grow over the output limits, unless the source is already larger
+		rotSize := imgRotatedSize(imgBounds, pars.Rotate)
+		srcSide := imgBounds.X
+		if imgBounds.Y > srcSide {
+			srcSide = imgBounds.Y
+		}
+		maxW, maxH := c.maxOutSize()
+		if (maxW > 0 && rotSize.X > maxW && rotSize.X > srcSide) || (maxH > 0 && rotSize.Y > maxH && rotSize.Y > srcSide) {
+			return nil, false, errs.ImgTooLarge
+		}
+
+		// imaging rotates counter-clockwise
+		img = imaging.Rotate(img, -pars.Rotate, bg)
+		imgBounds = img.Bounds().Size()
+
+		hasChanges = true
+	}
+
+	if pars.FlipH {
+		img = imaging.FlipH(img)
+		hasChanges = true
 	}
 
-	imgBounds := img.Bounds().Max
+	if pars.FlipV {
+		img = imaging.FlipV(img)
+		hasChanges = true
+	}
 
 	if pW > 0 || pH > 0 {
 		if pW == 0 {
@@ -83,39 +742,745 @@ func (c *Img) Handle(fPath string, w io.Writer, pars *types.ImgParsSt) error {
 		if imgBounds.X > pW || imgBounds.Y > pH {
 			if pM == "fit" {
 				img = imaging.Fit(img, pW, pH, imaging.Lanczos)
+			} else if pars.Gravity == "smart" {
+				img = imaging.Fill(imaging.Crop(img, smartCropRect(img, pW, pH)), pW, pH, imaging.Center, imaging.Lanczos)
 			} else {
-				img = imaging.Fill(img, pW, pH, imaging.Center, imaging.Lanczos)
+				img = imaging.Fill(img, pW, pH, imgGravities[pars.Gravity], imaging.Lanczos)
 			}
-
-			imgBounds = img.Bounds().Max
 		}
 
 		hasChanges = true
 	}
 
-	if pBlur != 0 {
-		img = imaging.Blur(img, pBlur)
+	if pars.Brightness != 0 {
+		img = imaging.AdjustBrightness(img, pars.Brightness)
+		hasChanges = true
 	}
 
-	if pGrayscale {
-		img = imaging.Grayscale(img)
+	if pars.Contrast != 0 {
+		img = imaging.AdjustContrast(img, pars.Contrast)
+		hasChanges = true
 	}
 
-	if hasChanges {
-		if w == nil {
-			err = imaging.Save(img, fPath)
-			if err != nil {
-				c.r.lg.Errorw("Fail to save image", err)
-				return err
-			}
-		} else {
-			err = imaging.Encode(w, img, imgFormat.format)
-			if err != nil {
-				c.r.lg.Errorw("Fail to encode image", err)
-				return err
+	if pars.Gamma != 0 && pars.Gamma != 1 {
+		img = imaging.AdjustGamma(img, pars.Gamma)
+		hasChanges = true
+	}
+
+	if pars.Saturation != 0 {
+		img = imaging.AdjustSaturation(img, pars.Saturation)
+		hasChanges = true
+	}
+
+	if pars.Sharpen != 0 {
+		img = imaging.Sharpen(img, pars.Sharpen)
+		hasChanges = true
+	}
+
+	if pBlur != 0 {
+		img = imaging.Blur(img, pBlur)
+		hasChanges = true
+	}
+
+	if pGrayscale {
+		img = imaging.Grayscale(img)
+		hasChanges = true
+	}
+
+	if pars.Invert {
+		img = imaging.Invert(img)
+		hasChanges = true
+	}
+
+	if pars.WMark {
+		img = c.drawWMark(img)
+		hasChanges = true
+	}
+
+	return img, hasChanges, nil
+}
+
+// drawWMark draws configured watermark over the image
+func (c *Img) drawWMark(img image.Image) image.Image {
+	opts := &c.r.imgOpts
+
+	mark := opts.WMark
+	if mark == nil {
+		return img
+	}
+
+	imgSize := img.Bounds().Size()
+
+	if opts.WMarkScale > 0 {
+		markW := int(math.Round(float64(imgSize.X) * opts.WMarkScale))
+		if markW < 1 {
+			markW = 1
+		}
+
+		mark = imaging.Resize(mark, markW, 0, imaging.Lanczos)
+	}
+
+	pos := img.Bounds().Min.Add(imgAnchorPoint(imgSize, mark.Bounds().Size(), imgGravities[opts.WMarkPosition], opts.WMarkMargin))
+
+	return imaging.Overlay(img, mark, pos, opts.WMarkOpacity)
+}
+
+// imgAnchorPoint returns position of the box of size `size` anchored inside of the area of size `area`
+func imgAnchorPoint(area image.Point, size image.Point, anchor imaging.Anchor, margin int) image.Point {
+	left := margin
+	right := area.X - size.X - margin
+	centerX := (area.X - size.X) / 2
+	top := margin
+	bottom := area.Y - size.Y - margin
+	centerY := (area.Y - size.Y) / 2
+
+	switch anchor {
+	case imaging.TopLeft:
+		return image.Pt(left, top)
+	case imaging.Top:
+		return image.Pt(centerX, top)
+	case imaging.TopRight:
+		return image.Pt(right, top)
+	case imaging.Left:
+		return image.Pt(left, centerY)
+	case imaging.Right:
+		return image.Pt(right, centerY)
+	case imaging.BottomLeft:
+		return image.Pt(left, bottom)
+	case imaging.Bottom:
+		return image.Pt(centerX, bottom)
+	case imaging.BottomRight:
+		return image.Pt(right, bottom)
+	default:
+		return image.Pt(centerX, centerY)
+	}
+}
+
+// smartCropRect returns region of the image with aspect ratio w:h which has the most details.
+// Candidate regions are compared by entropy of luminance on a small thumbnail.
+func smartCropRect(img image.Image, w int, h int) image.Rectangle {
+	bounds := img.Bounds()
+
+	cw, ch := bounds.Dx(), bounds.Dx()*h/w
+	if ch > bounds.Dy() {
+		cw, ch = bounds.Dy()*w/h, bounds.Dy()
+	}
+	if cw < 1 {
+		cw = 1
+	}
+	if ch < 1 {
+		ch = 1
+	}
+
+	scale := 1.0
+	if maxSide := math.Max(float64(bounds.Dx()), float64(bounds.Dy())); maxSide > imgSmartCropThumbSize {
+		scale = imgSmartCropThumbSize / maxSide
+	}
+
+	thumbW := int(math.Max(1, math.Round(float64(bounds.Dx())*scale)))
+	thumbH := int(math.Max(1, math.Round(float64(bounds.Dy())*scale)))
+
+	thumb := imaging.Grayscale(imaging.Resize(img, thumbW, thumbH, imaging.Box))
+
+	winW := int(math.Min(float64(thumbW), math.Max(1, math.Round(float64(cw)*scale))))
+	winH := int(math.Min(float64(thumbH), math.Max(1, math.Round(float64(ch)*scale))))
+
+	slackX, slackY := thumbW-winW, thumbH-winH
+
+	bestX, bestY := slackX/2, slackY/2
+	bestEntropy, bestDist := -1.0, 0
+
+	for i := 0; i <= imgSmartCropSteps; i++ {
+		for j := 0; j <= imgSmartCropSteps; j++ {
+			x, y := slackX*i/imgSmartCropSteps, slackY*j/imgSmartCropSteps
+
+			entropy := imgEntropy(thumb, image.Rect(x, y, x+winW, y+winH))
+
+			// on equal entropy region closer to the center wins
+			dist := (x-slackX/2)*(x-slackX/2) + (y-slackY/2)*(y-slackY/2)
+
+			if entropy > bestEntropy+1e-9 || (math.Abs(entropy-bestEntropy) <= 1e-9 && dist < bestDist) {
+				bestX, bestY, bestEntropy, bestDist = x, y, entropy, dist
 			}
 		}
 	}
 
+	x := int(math.Min(float64(bounds.Dx()-cw), math.Round(float64(bestX)/scale)))
+	y := int(math.Min(float64(bounds.Dy()-ch), math.Round(float64(bestY)/scale)))
+
+	return image.Rect(x, y, x+cw, y+ch).Add(bounds.Min)
+}
+
+// parseImgColor parses color in hex form: rrggbb or rrggbbaa, empty value is transparent
+func parseImgColor(v string) (color.NRGBA, error) {
+	if v == "" {
+		return color.NRGBA{}, nil
+	}
+
+	if len(v) == 6 {
+		v += "ff"
+	}
+
+	if len(v) != 8 {
+		return color.NRGBA{}, errs.BadImgParams
+	}
+
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		return color.NRGBA{}, errs.BadImgParams
+	}
+
+	return color.NRGBA{R: b[0], G: b[1], B: b[2], A: b[3]}, nil
+}
+
+// imgRotatedSize returns size of the canvas which holds the image rotated by angle degrees
+func imgRotatedSize(size image.Point, angle float64) image.Point {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	w, h := float64(size.X), float64(size.Y)
+
+	return image.Pt(
+		int(math.Ceil(math.Abs(w*cos)+math.Abs(h*sin)-1e-9)),
+		int(math.Ceil(math.Abs(w*sin)+math.Abs(h*cos)-1e-9)),
+	)
+}
+
+// imgEntropy returns shannon entropy of luminance histogram of the region of grayscale image
+func imgEntropy(img *image.NRGBA, rect image.Rectangle) float64 {
+	var hist [32]int
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			hist[img.Pix[img.PixOffset(x, y)]>>3]++
+		}
+	}
+
+	total := float64(rect.Dx() * rect.Dy())
+
+	result := 0.0
+
+	for _, cnt := range hist {
+		if cnt > 0 {
+			p := float64(cnt) / total
+			result -= p * math.Log2(p)
+		}
+	}
+
+	return result
+}
+
+// handleAnimation transforms every frame of animated gif.
+// Frames are composed on the canvas the way viewer does it, so partial frames are transformed in context,
+// and written back as full frames with original delays and disposal methods.
+func (c *Img) handleAnimation(anim *gif.GIF, w io.Writer, pars *types.ImgParsSt) error {
+	hasChanges := false
+
+	frames := make([]*image.Paletted, len(anim.Image))
+
+	framePars := pars
+
+	err := composeGifFrames(anim, len(anim.Image)-1, func(i int, canvas *image.RGBA) error {
+		// smart region is chosen by the first frame, otherwise it would jump from frame to frame
+		if i == 0 && pars.Gravity == "smart" && pars.Method != "fit" && pars.Method != "crop" && pars.Width > 0 && pars.Height > 0 {
+			rect := smartCropRect(canvas, pars.Width, pars.Height)
+
+			framePars = &types.ImgParsSt{}
+			*framePars = *pars
+			framePars.Method = "crop"
+			framePars.CropX, framePars.CropY = rect.Min.X, rect.Min.Y
+			framePars.CropW, framePars.CropH = rect.Dx(), rect.Dy()
+		}
+
+		img, changed, err := c.transform(canvas, framePars)
+		if err != nil {
+			return err
+		}
+		if changed {
+			hasChanges = true
+		}
+
+		frames[i] = gifPaletted(img, gifPalette(anim, i))
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !hasChanges {
+		return nil
+	}
+
+	anim.Image = frames
+	anim.Config.Width = frames[0].Bounds().Dx()
+	anim.Config.Height = frames[0].Bounds().Dy()
+
+	err = gif.EncodeAll(w, anim)
+	if err != nil {
+		c.r.lg.Errorw("Fail to encode image", err)
+		return err
+	}
+
+	return nil
+}
+
+// gifFrame returns frame of gif as it is shown by viewer
+func gifFrame(anim *gif.GIF, idx int) (image.Image, error) {
+	var result *image.RGBA
+
+	err := composeGifFrames(anim, idx, func(i int, canvas *image.RGBA) error {
+		if i == idx {
+			result = image.NewRGBA(canvas.Rect)
+			draw.Draw(result, result.Rect, canvas, image.Point{}, draw.Src)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// composeGifFrames draws frames up to lastIdx one by one on the canvas and calls cb after each frame is drawn
+func composeGifFrames(anim *gif.GIF, lastIdx int, cb func(i int, canvas *image.RGBA) error) error {
+	if lastIdx < 0 || lastIdx >= len(anim.Image) {
+		return errs.BadImgFrame
+	}
+
+	bounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	for _, frame := range anim.Image {
+		bounds = bounds.Union(frame.Bounds())
+	}
+
+	canvas := image.NewRGBA(bounds)
+
+	var prev *image.RGBA
+
+	for i, frame := range anim.Image[:lastIdx+1] {
+		disposal := byte(0)
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+
+		if disposal == gif.DisposalPrevious {
+			prev = image.NewRGBA(bounds)
+			draw.Draw(prev, bounds, canvas, bounds.Min, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		err := cb(i, canvas)
+		if err != nil {
+			return err
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = prev
+		}
+	}
+
+	return nil
+}
+
+// gifPalette returns palette for the frame, a transparent color is added if there is room for it
+func gifPalette(anim *gif.GIF, i int) color.Palette {
+	palette, _ := anim.Config.ColorModel.(color.Palette)
+	if len(palette) == 0 {
+		palette = anim.Image[i].Palette
+	}
+
+	for _, clr := range palette {
+		if _, _, _, a := clr.RGBA(); a == 0 {
+			return palette
+		}
+	}
+
+	if len(palette) < 256 {
+		palette = append(append(color.Palette{}, palette...), color.RGBA{})
+	}
+
+	return palette
+}
+
+func gifPaletted(img image.Image, palette color.Palette) *image.Paletted {
+	bounds := img.Bounds()
+
+	result := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+
+	draw.Draw(result, result.Rect, img, bounds.Min, draw.Src)
+
+	return result
+}
+
+// ResolvePars returns parameters of the preset if it is requested.
+// Preset can not be combined with ad-hoc parameters, and in strict mode ad-hoc parameters are not allowed at all.
+// Dpr is not an ad-hoc parameter, it can be combined with preset.
+func (c *Img) ResolvePars(preset string, pars *types.ImgParsSt) (*types.ImgParsSt, error) {
+	adHocPars := *pars
+	adHocPars.Dpr = 0
+
+	if !adHocPars.IsEmpty() {
+		if c.r.imgOpts.Strict {
+			return nil, errs.ImgParamsNotAllowed
+		}
+
+		if preset != "" {
+			return nil, errs.BadImgParams
+		}
+	}
+
+	if preset == "" {
+		return pars, nil
+	}
+
+	presetPars, ok := c.r.imgOpts.Presets[preset]
+	if !ok {
+		return nil, errs.BadImgPreset
+	}
+
+	result := *presetPars
+	result.Dpr = pars.Dpr
+
+	return &result, nil
+}
+
+// ValidatePresets checks parameters of all configured presets
+func (c *Img) ValidatePresets() error {
+	for name, presetPars := range c.r.imgOpts.Presets {
+		pars := *presetPars
+
+		// resolved per request from Accept header
+		if pars.Format == "auto" {
+			pars.Format = ""
+		}
+
+		err := c.ValidatePars(&pars)
+		if err != nil {
+			return fmt.Errorf("bad image preset %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateWMark checks watermark options
+func (c *Img) ValidateWMark() error {
+	opts := &c.r.imgOpts
+
+	if opts.WMark == nil {
+		if len(opts.WMarkDirPaths) > 0 || opts.WMarkOnDemand {
+			return errors.New("watermark image is not set")
+		}
+
+		return nil
+	}
+
+	if _, ok := imgGravities[opts.WMarkPosition]; !ok {
+		return fmt.Errorf("bad watermark position %q", opts.WMarkPosition)
+	}
+
+	if opts.WMarkOpacity <= 0 || opts.WMarkOpacity > 1 {
+		return errors.New("watermark opacity must be in (0, 1]")
+	}
+
+	if opts.WMarkScale < 0 || opts.WMarkScale > 1 {
+		return errors.New("watermark scale must be in [0, 1]")
+	}
+
 	return nil
 }
+
+// ValidatePars checks that requested output format and encoder options are supported
+func (c *Img) ValidatePars(pars *types.ImgParsSt) error {
+	if pars.WMark && !c.r.imgOpts.WMarkOnDemand {
+		return errs.ImgParamsNotAllowed
+	}
+
+	if pars.Format != "" {
+		if _, ok := imgFormats[pars.Format]; !ok {
+			return errs.BadImgFormat
+		}
+	}
+
+	if pars.PngCompression != "" {
+		if _, ok := imgPngCompressions[pars.PngCompression]; !ok {
+			return errs.BadImgParams
+		}
+	}
+
+	if !imgMethods[pars.Method] {
+		return errs.BadImgParams
+	}
+
+	if _, ok := imgGravities[pars.Gravity]; !ok && pars.Gravity != "smart" {
+		return errs.BadImgParams
+	}
+
+	if pars.Width < 0 || pars.Height < 0 {
+		return errs.BadImgParams
+	}
+
+	// zero is the server default
+	if pars.Quality < 0 || pars.Quality > 100 {
+		return errs.BadImgParams
+	}
+
+	if _, err := parseImgColor(pars.Background); err != nil {
+		return err
+	}
+
+	// imaging panics on them
+	for _, v := range []float64{pars.Rotate, pars.Sharpen, pars.Blur, pars.Brightness, pars.Contrast, pars.Gamma, pars.Saturation, pars.Dpr} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errs.BadImgParams
+		}
+	}
+
+	for _, v := range []float64{pars.Brightness, pars.Contrast, pars.Saturation} {
+		if v < -100 || v > 100 {
+			return errs.BadImgParams
+		}
+	}
+
+	if pars.Gamma < 0 || pars.Sharpen < 0 || pars.Blur < 0 {
+		return errs.BadImgParams
+	}
+
+	if pars.Sharpen > cns.ImgMaxSigma || pars.Blur > cns.ImgMaxSigma {
+		return errs.BadImgParams
+	}
+
+	if pars.Dpr < 0 || pars.Dpr > cns.ImgMaxDpr {
+		return errs.BadImgParams
+	}
+
+	if (c.r.imgOpts.MaxOutWidth > 0 && pars.Width > c.r.imgOpts.MaxOutWidth) ||
+		(c.r.imgOpts.MaxOutHeight > 0 && pars.Height > c.r.imgOpts.MaxOutHeight) {
+		return errs.ImgTooLarge
+	}
+
+	if pars.Method == "crop" && (pars.CropX < 0 || pars.CropY < 0 || pars.CropW <= 0 || pars.CropH <= 0) {
+		return errs.BadImgParams
+	}
+
+	return nil
+}
+
+// ApplyDpr multiplies requested sizes by pixel ratio, resulting sizes do not exceed server maximum.
+// Sizes are not scaled over imgSize of the source (zero is unknown), but they are never made smaller than requested.
+func (c *Img) ApplyDpr(pars *types.ImgParsSt, imgSize image.Point) {
+	dpr := pars.Dpr
+	pars.Dpr = 0
+
+	if dpr == 0 || dpr == 1 || (pars.Width == 0 && pars.Height == 0) {
+		return
+	}
+
+	if dpr > 1 && imgSize.X > 0 && imgSize.Y > 0 {
+		srcDpr := dpr
+		if pars.Width > 0 {
+			srcDpr = math.Min(srcDpr, float64(imgSize.X)/float64(pars.Width))
+		}
+		if pars.Height > 0 {
+			srcDpr = math.Min(srcDpr, float64(imgSize.Y)/float64(pars.Height))
+		}
+
+		dpr = math.Max(srcDpr, 1)
+	}
+
+	maxWidth, maxHeight := c.maxOutSize()
+
+	// the same factor for both sides keeps aspect ratio
+	if pars.Width > 0 && maxWidth > 0 {
+		dpr = math.Min(dpr, float64(maxWidth)/float64(pars.Width))
+	}
+	if pars.Height > 0 && maxHeight > 0 {
+		dpr = math.Min(dpr, float64(maxHeight)/float64(pars.Height))
+	}
+
+	pars.Width = int(math.Round(float64(pars.Width) * dpr))
+	pars.Height = int(math.Round(float64(pars.Height) * dpr))
+}
+
+// Bounds returns size of the image after EXIF orientation is applied, without decoding of pixels
+func (c *Img) Bounds(fName string, src io.ReadSeeker) (image.Point, error) {
+	srcFormat, ok := imgFileTypes[strings.ToLower(filepath.Ext(fName))]
+	if !ok {
+		return image.Point{}, errs.NotImg
+	}
+
+	cfg, _, err := image.DecodeConfig(src)
+	if err != nil {
+		return image.Point{}, errs.NotImg
+	}
+
+	result := image.Pt(cfg.Width, cfg.Height)
+
+	// only jpeg orientation is applied on decoding
+	if srcFormat == "jpeg" {
+		_, err = src.Seek(0, io.SeekStart)
+		if err != nil {
+			return image.Point{}, err
+		}
+
+		if x, err := exif.Decode(src); err == nil {
+			if tag, err := x.Get(exif.Orientation); err == nil {
+				if v, err := tag.Int(0); err == nil && v >= 5 && v <= 8 {
+					result.X, result.Y = result.Y, result.X
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Srcset returns candidates of srcset for the image of size imgSize.
+// For preset they are pixel ratios, otherwise requested widths.
+// Candidates are never larger than the original image and server maximum, bigger ones are replaced by the largest possible.
+func (c *Img) Srcset(imgSize image.Point, preset string, widths []int) ([]*types.SrcsetItemSt, error) {
+	result := make([]*types.SrcsetItemSt, 0)
+
+	if preset != "" {
+		if len(widths) > 0 {
+			return nil, errs.BadImgParams
+		}
+
+		presetPars, ok := c.r.imgOpts.Presets[preset]
+		if !ok {
+			return nil, errs.BadImgPreset
+		}
+
+		for _, dpr := range cns.ImgSrcsetDprs {
+			if dpr > 1 {
+				pars := &types.ImgParsSt{Width: presetPars.Width, Height: presetPars.Height, Dpr: dpr}
+				c.ApplyDpr(pars, imgSize)
+
+				noSize := presetPars.Width == 0 && presetPars.Height == 0
+				capped := pars.Width != int(math.Round(float64(presetPars.Width)*dpr)) ||
+					pars.Height != int(math.Round(float64(presetPars.Height)*dpr))
+
+				// the rest would not be sharper than the previous one, because of server maximum or size of original
+				if noSize || capped {
+					break
+				}
+			}
+
+			result = append(result, &types.SrcsetItemSt{Dpr: dpr})
+		}
+
+		return result, nil
+	}
+
+	if len(widths) == 0 {
+		return nil, errs.BadImgParams
+	}
+
+	if c.r.imgOpts.Strict {
+		return nil, errs.ImgParamsNotAllowed
+	}
+
+	maxWidth := imgSize.X
+	if maxOutWidth, _ := c.maxOutSize(); maxOutWidth > 0 && maxOutWidth < maxWidth {
+		maxWidth = maxOutWidth
+	}
+
+	widths = append([]int{}, widths...)
+	sort.Ints(widths)
+
+	for _, w := range widths {
+		if w <= 0 {
+			return nil, errs.BadImgParams
+		}
+
+		if w > maxWidth {
+			w = maxWidth
+		}
+
+		if len(result) > 0 && result[len(result)-1].Width == w {
+			continue
+		}
+
+		result = append(result, &types.SrcsetItemSt{Width: w})
+	}
+
+	return result, nil
+}
+
+// encodeOpts fills encoder options from request, missing ones are taken from server defaults, quality is clamped
+func (c *Img) encodeOpts(pars *types.ImgParsSt) *imgEncodeOptsSt {
+	opts := &c.r.imgOpts
+
+	result := &imgEncodeOptsSt{
+		quality:        pars.Quality,
+		pngCompression: imgPngCompressions[opts.PngCompression],
+	}
+
+	if result.quality <= 0 {
+		result.quality = opts.Quality
+	}
+	if result.quality <= 0 {
+		result.quality = cns.ImgQuality
+	}
+
+	if opts.QualityMin > 0 && result.quality < opts.QualityMin {
+		result.quality = opts.QualityMin
+	}
+	if opts.QualityMax > 0 && result.quality > opts.QualityMax {
+		result.quality = opts.QualityMax
+	}
+	if result.quality > 100 {
+		result.quality = 100
+	}
+
+	if pars.PngCompression != "" {
+		result.pngCompression = imgPngCompressions[pars.PngCompression]
+	}
+
+	return result
+}
+
+// NegotiateFormat picks output format for f=auto from value of Accept header.
+// Returns empty string if client accepts none of them, so the source format is kept.
+func (c *Img) NegotiateFormat(accept string) string {
+	accepted := map[string]bool{}
+
+	for _, v := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(v), ";")
+		if strings.ReplaceAll(params, " ", "") == "q=0" {
+			continue
+		}
+		accepted[strings.ToLower(strings.TrimSpace(mediaType))] = true
+	}
+
+	for _, f := range imgAutoFormats {
+		if accepted[imgFormats[f].contentType] {
+			return f
+		}
+	}
+
+	return ""
+}
+
+// FileName returns name of the file produced by Handle, extension follows the output format
+func (c *Img) FileName(fName string, pars *types.ImgParsSt) string {
+	if pars.Format == "" {
+		return fName
+	}
+
+	imgFormat, ok := imgFormats[pars.Format]
+	if !ok {
+		return fName
+	}
+
+	fileExt := filepath.Ext(fName)
+
+	if imgFileTypes[strings.ToLower(fileExt)] == pars.Format {
+		return fName
+	}
+
+	return strings.TrimSuffix(fName, fileExt) + imgFormat.ext
+}
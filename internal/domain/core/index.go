@@ -2,51 +2,116 @@ package core
 
 import (
 	"context"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/rendau/dop/adapters/logger"
+
+	"github.com/rendau/kazan/internal/adapters/storage"
+	"github.com/rendau/kazan/internal/cns"
+	"github.com/rendau/kazan/internal/domain/util"
 )
 
 type St struct {
 	lg           logger.Lite
+	storage      storage.Storage
 	imgMaxWidth  int
 	imgMaxHeight int
+	imgOpts      ImgOptionsSt
+	cacheOpts    CacheOptionsSt
 	testing      bool
 
+	// wMarkDirPaths are fs-paths with trailing separator, images inside of them are always watermarked
+	wMarkDirPaths []string
+
+	zipOpts    ZipOptionsSt
+	uploadOpts UploadOptionsSt
+
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 
-	Static *Static
-	Img    *Img
+	Cache      *Cache
+	Derivative *Derivative
+	Static     *Static
+	Img        *Img
+	Zip        *Zip
+	Dedup      *Dedup
+	Meta       *Meta
+	Upload     *Upload
 
 	wg sync.WaitGroup
 }
 
 func New(
 	lg logger.Lite,
+	storage storage.Storage,
 	imgMaxWidth int,
 	imgMaxHeight int,
+	imgOpts ImgOptionsSt,
+	cacheOpts CacheOptionsSt,
+	zipOpts ZipOptionsSt,
+	uploadOpts UploadOptionsSt,
 	testing bool,
 ) *St {
 	c := &St{
 		lg:           lg,
+		storage:      storage,
 		imgMaxWidth:  imgMaxWidth,
 		imgMaxHeight: imgMaxHeight,
+		imgOpts:      imgOpts,
+		cacheOpts:    cacheOpts,
 		testing:      testing,
+		zipOpts:      zipOpts,
+		uploadOpts:   uploadOpts,
+	}
+
+	for _, p := range imgOpts.WMarkDirPaths {
+		p = util.ToFsPath(p)
+		if p != "" {
+			p += string(filepath.Separator)
+		}
+		c.wMarkDirPaths = append(c.wMarkDirPaths, p)
 	}
 
 	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
 
+	c.Cache = NewCache(c)
+	c.Derivative = NewDerivative(c)
 	c.Static = NewStatic(c)
 	c.Img = NewImg(c)
+	c.Zip = NewZip(c)
+	c.Dedup = NewDedup(c)
+	c.Meta = NewMeta(c)
+	c.Upload = NewUpload(c)
 
 	return c
 }
 
 func (c *St) Start() {
+	_ = c.Derivative.Load()
+
+	c.wg.Add(1)
+	go c.uploadCleanRoutine()
 }
 
 func (c *St) StopAndWaitJobs() {
 	c.ctxCancel()
 	c.wg.Wait()
 }
+
+func (c *St) uploadCleanRoutine() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(cns.UploadCleanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.Upload.RemoveExpired()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+
+	"github.com/rendau/dop/dopErrs"
+
+	"github.com/rendau/kazan/internal/cns"
+	"github.com/rendau/kazan/internal/domain/types"
+)
+
+// Meta keeps metadata records of stored objects in a sidecar area
+type Meta struct {
+	r *St
+}
+
+func NewMeta(r *St) *Meta {
+	return &Meta{
+		r: r,
+	}
+}
+
+func (c *Meta) Set(filePath string, meta *types.StaticMetaSt) error {
+	dataRaw, err := json.Marshal(meta)
+	if err != nil {
+		c.r.lg.Errorw("Fail to marshal meta", err)
+		return err
+	}
+
+	return c.r.storage.Put(c.metaPath(filePath), bytes.NewReader(dataRaw))
+}
+
+// Get returns nil if object has no metadata record
+func (c *Meta) Get(filePath string) (*types.StaticMetaSt, error) {
+	fReader, err := c.r.storage.Get(c.metaPath(filePath))
+	if err != nil {
+		if err == dopErrs.ObjectNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fReader.Close()
+
+	result := &types.StaticMetaSt{}
+
+	err = json.NewDecoder(fReader).Decode(result)
+	if err != nil {
+		c.r.lg.Errorw("Fail to decode meta", err, "f_path", filePath)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *Meta) Remove(filePath string) error {
+	metaPath := c.metaPath(filePath)
+
+	err := c.r.storage.Remove(metaPath)
+	if err != nil && err != dopErrs.ObjectNotFound {
+		return err
+	}
+
+	c.r.Static.pruneDateDirs(path.Dir(metaPath))
+
+	return nil
+}
+
+func (c *Meta) metaPath(filePath string) string {
+	return path.Join(cns.MetaDirName, filePath) + ".json"
+}
@@ -2,18 +2,25 @@ package core
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
 	"io"
-	"io/ioutil"
-	"os"
+	"mime"
+	"net/http"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/rendau/dop/dopErrs"
 
+	"github.com/rendau/kazan/internal/adapters/storage"
 	"github.com/rendau/kazan/internal/cns"
 	"github.com/rendau/kazan/internal/domain/errs"
 	"github.com/rendau/kazan/internal/domain/types"
+	"github.com/rendau/kazan/internal/domain/util"
 )
 
 type Static struct {
@@ -26,175 +33,897 @@ func NewStatic(r *St) *Static {
 	}
 }
 
-func (c *Static) Create(reqDir string, reqFileName string, reqFile io.Reader, noCut bool, unZip bool) (string, error) {
+// Create stores the file, stripMeta overrides server default of stripping image metadata if set
+func (c *Static) Create(reqDir string, reqFileName string, reqFile io.Reader, noCut bool, unZip bool, stripMeta *bool, tags []string) (string, error) {
 	reqDirUrlPath := util.ToUrlPath(reqDir)
 
-	if strings.Contains("/"+reqDirUrlPath, "/"+cns.ZipDirNamePrefix) {
-		return "", errs.BadDirName
-	}
-
-	if strings.HasPrefix("/"+reqDirUrlPath, "/"+cns.KvsDirNamePrefix) {
-		return "", errs.BadDirName
+	err := c.checkDir(reqDirUrlPath)
+	if err != nil {
+		return "", err
 	}
 
 	dateUrlPath := util.GetDateUrlPath()
 
-	absFsDirPath := filepath.Join(c.r.dirPath, util.ToFsPath(reqDir), util.ToFsPath(dateUrlPath))
-
-	err := os.MkdirAll(absFsDirPath, os.ModePerm)
-	if err != nil {
-		c.r.lg.Errorw("Fail to create dirs", err)
-		return "", err
-	}
+	dirUrlPath := path.Join(reqDirUrlPath, dateUrlPath)
 
 	reqFileExt := strings.ToLower(filepath.Ext(reqFileName))
 
-	var targetFsPath string
-	var isZipDir bool
+	var fileUrlRelPath string
+
+	meta := &types.StaticMetaSt{
+		OriginalName: filepath.Base(reqFileName),
+		UploadedAt:   time.Now(),
+		Tags:         tags,
+	}
 
 	if unZip && reqFileExt == ".zip" {
-		targetFsPath, err = os.MkdirTemp(absFsDirPath, cns.ZipDirNamePrefix+"*")
+		fileUrlRelPath, err = c.newName(dirUrlPath, cns.ZipDirNamePrefix, "")
 		if err != nil {
-			c.r.lg.Errorw("Fail to create temp-dir", err)
 			return "", err
 		}
 
-		err = c.r.Zip.Extract(reqFile, targetFsPath)
+		hasher := sha256.New()
+		counter := &countWriter{}
+
+		err = c.r.Zip.Extract(io.TeeReader(reqFile, io.MultiWriter(hasher, counter)), fileUrlRelPath)
 		if err != nil {
+			_ = c.r.storage.Remove(fileUrlRelPath)
 			return "", err
 		}
 
-		isZipDir = true
+		meta.ContentType = "application/zip"
+		meta.Size = counter.n
+		meta.Sha256 = hex.EncodeToString(hasher.Sum(nil))
 	} else {
-		targetFsPath, err = func() (string, error) {
-			f, err := os.CreateTemp(absFsDirPath, "*"+reqFileExt)
-			if err != nil {
-				c.r.lg.Errorw("Fail to create temp-file", err)
-				return "", err
-			}
-			defer f.Close()
+		fileUrlRelPath, err = c.newName(dirUrlPath, "", reqFileExt)
+		if err != nil {
+			return "", err
+		}
+
+		strip := c.r.imgOpts.StripMeta
+		if stripMeta != nil {
+			strip = *stripMeta
+		}
 
-			_, err = io.Copy(f, reqFile)
+		if c.r.uploadOpts.Dedup {
+			meta.Sha256, err = c.r.Dedup.Create(fileUrlRelPath, reqFile, noCut, strip)
+		} else {
+			meta.Sha256, err = c.putFile(fileUrlRelPath, reqFile, noCut, strip)
 			if err != nil {
-				c.r.lg.Errorw("Fail to copy data", err)
-				return "", err
+				_ = c.r.storage.Remove(fileUrlRelPath)
 			}
-
-			return f.Name(), nil
-		}()
+		}
 		if err != nil {
 			return "", err
 		}
 
-		if !noCut {
-			err = c.r.Img.Handle(targetFsPath, nil, &types.ImgParsSt{
-				Method: "fit",
-				Width:  c.r.imgMaxWidth,
-				Height: c.r.imgMaxHeight,
-			})
-			if err != nil {
-				return "", err
-			}
+		meta.ContentType, meta.Size, err = c.detectContentType(fileUrlRelPath)
+		if err != nil {
+			return "", err
 		}
 	}
 
-	fileFsRelPath, err := filepath.Rel(c.r.dirPath, targetFsPath)
+	err = c.r.Meta.Set(fileUrlRelPath, meta)
 	if err != nil {
-		c.r.lg.Errorw("Fail to get relative path", err, "path", targetFsPath, "base", c.r.dirPath)
 		return "", err
 	}
 
-	fileUrlRelPath := util.ToUrlPath(fileFsRelPath)
-
-	if isZipDir {
+	if strings.HasPrefix(path.Base(fileUrlRelPath), cns.ZipDirNamePrefix) {
 		fileUrlRelPath += "/"
 	}
 
 	return fileUrlRelPath, nil
 }
 
-func (c *Static) Get(reqPath string, imgPars *types.ImgParsSt, download bool) (string, time.Time, []byte, error) {
+func (c *Static) checkDir(dirUrlPath string) error {
+	if strings.Contains("/"+dirUrlPath, "/"+cns.ZipDirNamePrefix) {
+		return errs.BadDirName
+	}
+
+	if c.isReservedPath(dirUrlPath) {
+		return errs.BadDirName
+	}
+
+	return nil
+}
+
+// putFile stores the file (fitting image and stripping its metadata if needed) and returns sha256 of the stored content
+func (c *Static) putFile(filePath string, src io.Reader, noCut bool, stripMeta bool) (string, error) {
+	hasher := sha256.New()
+
+	err := c.r.storage.Put(filePath, io.TeeReader(src, hasher))
+	if err != nil {
+		return "", err
+	}
+
+	changed := false
+
+	if !noCut {
+		changed, err = c.fitImg(filePath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// re-encoded image has no metadata already
+	if stripMeta && !changed {
+		changed, err = c.stripImgMeta(filePath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if changed {
+		hasher.Reset()
+
+		err = c.copyFile(filePath, hasher)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// GetMeta returns metadata record of the object, or nil if there is no one
+func (c *Static) GetMeta(reqPath string) (*types.StaticMetaSt, error) {
+	filePath := strings.Trim(path.Clean("/"+reqPath), "/")
+
+	if filePath == "" || c.isReservedPath(filePath) {
+		return nil, dopErrs.ObjectNotFound
+	}
+
+	_, err := c.r.storage.Stat(filePath)
+	if err != nil {
+		return nil, dopErrs.ObjectNotFound
+	}
+
+	return c.r.Meta.Get(filePath)
+}
+
+// Info returns properties of the image file
+func (c *Static) Info(reqPath string) (*types.ImgInfoSt, error) {
+	fName, _, filePath, fInfo, err := c.resolveFile(reqPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fReader, err := c.r.storage.Get(filePath)
+	if err != nil {
+		if err != dopErrs.ObjectNotFound {
+			c.r.lg.Errorw("Fail to open file", err, "f_path", filePath)
+		}
+		return nil, err
+	}
+	defer fReader.Close()
+
+	release, err := c.r.Img.acquireDecode()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	result, err := c.r.Img.Info(fName, fReader)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Size = fInfo.Size
+
+	return result, nil
+}
+
+// Placeholder returns placeholder of the image, it is computed once and kept in metadata of the file.
+// Images of watermark directories have no placeholders, they would be made of originals.
+func (c *Static) Placeholder(reqPath string) (*types.ImgPlaceholderSt, error) {
+	fName, metaPath, filePath, _, err := c.resolveFile(reqPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.isWMarkPath(metaPath) {
+		return nil, dopErrs.PermissionDenied
+	}
+
+	meta, err := c.r.Meta.Get(metaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta != nil && meta.BlurHash != "" {
+		return &types.ImgPlaceholderSt{BlurHash: meta.BlurHash, Lqip: meta.Lqip}, nil
+	}
+
+	fReader, err := c.r.storage.Get(filePath)
+	if err != nil {
+		if err != dopErrs.ObjectNotFound {
+			c.r.lg.Errorw("Fail to open file", err, "f_path", filePath)
+		}
+		return nil, err
+	}
+	defer fReader.Close()
+
+	release, err := c.r.Img.acquireDecode()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	result, err := c.r.Img.Placeholder(fName, fReader)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta != nil {
+		meta.BlurHash = result.BlurHash
+		meta.Lqip = result.Lqip
+
+		err = c.r.Meta.Set(metaPath, meta)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Srcset returns srcset candidates for the image, see Img.Srcset
+func (c *Static) Srcset(reqPath string, preset string, widths []int) ([]*types.SrcsetItemSt, error) {
+	imgSize, err := c.imgBounds(reqPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.r.Img.Srcset(imgSize, preset, widths)
+}
+
+// imgBounds returns size of the image from its header
+func (c *Static) imgBounds(reqPath string) (image.Point, error) {
+	fName, _, filePath, _, err := c.resolveFile(reqPath)
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	return c.fileBounds(filePath, fName)
+}
+
+// fileBounds returns size of the image stored at filePath, fName gives its format
+func (c *Static) fileBounds(filePath string, fName string) (image.Point, error) {
+	fReader, err := c.r.storage.Get(filePath)
+	if err != nil {
+		if err != dopErrs.ObjectNotFound {
+			c.r.lg.Errorw("Fail to open file", err, "f_path", filePath)
+		}
+		return image.Point{}, err
+	}
+	defer fReader.Close()
+
+	return c.r.Img.Bounds(fName, fReader)
+}
+
+// resolveFile finds regular file by request path, returns its name, path of its metadata and path of the content
+func (c *Static) resolveFile(reqPath string) (string, string, string, *storage.FileInfoSt, error) {
+	filePath := strings.Trim(path.Clean("/"+reqPath), "/")
+
+	if filePath == "" || c.isReservedPath(filePath) {
+		return "", "", "", nil, dopErrs.ObjectNotFound
+	}
+
+	fInfo, err := c.r.storage.Stat(filePath)
+	if err != nil || fInfo.IsDir {
+		return "", "", "", nil, dopErrs.ObjectNotFound
+	}
+
+	fName := fInfo.Name
+	metaPath := filePath
+
+	filePath, fInfo, err = c.r.Dedup.Resolve(filePath, fInfo)
+	if err != nil {
+		return "", "", "", nil, dopErrs.ObjectNotFound
+	}
+
+	return fName, metaPath, filePath, fInfo, nil
+}
+
+func (c *Static) Get(reqPath string, imgPars *types.ImgParsSt, download bool) (*types.StaticFileSt, error) {
 	var err error
 
+	err = c.r.Img.ValidatePars(imgPars)
+	if err != nil {
+		return nil, err
+	}
+
+	// pixel ratio over 1 is limited by size of the source, it is applied after cache lookup
+	if imgPars.Dpr <= 1 || (imgPars.Width == 0 && imgPars.Height == 0) {
+		c.r.Img.ApplyDpr(imgPars, image.Point{})
+	}
+
+	filePath := strings.Trim(path.Clean("/"+reqPath), "/")
+
+	// originals from these directories are never served, only watermarked derivatives
+	if c.isWMarkPath(filePath) {
+		imgPars.WMark = true
+	}
+
 	cKey := c.r.Cache.GenerateKey(reqPath, imgPars, download)
 
-	if name, modTime, content := c.r.Cache.GetAndRefresh(cKey); content != nil {
-		return name, modTime, content, nil
+	cPool := CachePoolRaw
+	if !imgPars.IsEmpty() {
+		cPool = CachePoolImg
 	}
 
-	reqFsPath := util.ToFsPath(reqPath)
-	absFsPath := filepath.Join(c.r.dirPath, reqFsPath)
+	if item := c.r.Cache.GetAndRefresh(cPool, cKey); item != nil {
+		file := c.bufferedFile(item.name, item.modTime, item.content)
+		file.Meta = item.meta
+
+		return file, nil
+	}
 
 	name := ""
+	metaPath := ""
 	modTime := time.Now()
-	content := make([]byte, 0)
+	srcModTime := time.Time{}
+
+	if c.isReservedPath(filePath) {
+		return nil, dopErrs.ObjectNotFound
+	}
 
-	fInfo, err := os.Stat(absFsPath)
+	fInfo, err := c.r.storage.Stat(filePath)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			c.r.lg.Errorw("Fail to get stat of file", err, "f_path", absFsPath)
+		if err != dopErrs.ObjectNotFound {
+			c.r.lg.Errorw("Fail to get stat of file", err, "f_path", filePath)
 		}
-		return "", modTime, nil, dopErrs.ObjectNotFound
+		return nil, dopErrs.ObjectNotFound
 	}
 
 	if !download {
-		modTime = fInfo.ModTime()
+		modTime = fInfo.ModTime
 	}
 
-	if fInfo.IsDir() {
-		dirName := filepath.Base(absFsPath)
+	if fInfo.IsDir {
+		dirName := path.Base(filePath)
 
 		if strings.HasPrefix(dirName, cns.ZipDirNamePrefix) {
 			if download {
-				archiveBuffer, err := c.r.Zip.CompressDir(absFsPath)
-				if err != nil {
-					return "", modTime, nil, err
+				// archive would contain originals of images
+				if imgPars.WMark {
+					return nil, dopErrs.PermissionDenied
 				}
 
-				return "archive.zip", modTime, archiveBuffer.Bytes(), nil
+				zipDirPath := filePath
+
+				return &types.StaticFileSt{
+					Name:    "archive.zip",
+					ModTime: modTime,
+					Size:    -1,
+					Stream: func(w io.Writer) error {
+						return c.r.Zip.CompressDir(w, zipDirPath)
+					},
+				}, nil
 			} else if strings.HasSuffix(reqPath, "/") {
-				absFsPath = filepath.Join(absFsPath, "index.html")
+				filePath = path.Join(filePath, "index.html")
 				name = "index.html"
 				imgPars.Reset()
+
+				fInfo, err = c.r.storage.Stat(filePath)
+				if err != nil {
+					return nil, dopErrs.ObjectNotFound
+				}
+
+				modTime = fInfo.ModTime
 			} else {
-				return "", modTime, nil, dopErrs.ObjectNotFound
+				return nil, dopErrs.ObjectNotFound
 			}
 		} else {
-			return "", modTime, nil, dopErrs.ObjectNotFound
+			return nil, dopErrs.ObjectNotFound
 		}
 	} else {
-		_, name = filepath.Split(absFsPath)
-	}
+		name = fInfo.Name
+		metaPath = filePath
+		srcModTime = fInfo.ModTime
 
-	for _, p := range c.r.wMarkDirPaths {
-		if strings.HasPrefix(reqFsPath, p) {
-			imgPars.WMark = true
-			break
+		filePath, fInfo, err = c.r.Dedup.Resolve(filePath, fInfo)
+		if err != nil {
+			return nil, dopErrs.ObjectNotFound
 		}
 	}
 
+	// size of the source is needed only to not scale it over the original by pixel ratio,
+	// if it is unknown, request fails later or goes without the limit
+	if imgPars.Dpr != 0 {
+		imgSize, _ := c.fileBounds(filePath, name)
+
+		c.r.Img.ApplyDpr(imgPars, imgSize)
+	}
+
+	// only image transformations are buffered, everything else is streamed from storage
 	if !imgPars.IsEmpty() {
+		// concurrent requests of the same derivative wait for a single transformation
+		item, err := c.r.Cache.Coalesce(cKey, func() (*cacheItemSt, error) {
+			return c.imgDerivative(cKey, filePath, metaPath, name, modTime, srcModTime, imgPars)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if item != nil {
+			return c.bufferedFile(item.name, item.modTime, item.content), nil
+		}
+	}
+
+	var meta *types.StaticMetaSt
+
+	if metaPath != "" {
+		meta, err = c.r.Meta.Get(metaPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := c.r.storage.Get(filePath)
+	if err != nil {
+		if err != dopErrs.ObjectNotFound {
+			c.r.lg.Errorw("Fail to open file", err, "f_path", filePath)
+		}
+		return nil, err
+	}
+
+	if c.r.Cache.Fits(CachePoolRaw, fInfo.Size) {
+		defer content.Close()
+
+		data, err := io.ReadAll(content)
+		if err != nil {
+			c.r.lg.Errorw("Fail to read file", err, "f_path", filePath)
+			return nil, err
+		}
+
+		// content is not transformed, so it is cached as raw one
+		if cPool != CachePoolRaw {
+			cKey = c.r.Cache.GenerateKey(reqPath, &types.ImgParsSt{}, download)
+		}
+
+		c.r.Cache.Set(CachePoolRaw, cKey, name, modTime, data, meta)
+
+		file := c.bufferedFile(name, modTime, data)
+		file.Meta = meta
+
+		return file, nil
+	}
+
+	return &types.StaticFileSt{
+		Name:    name,
+		ModTime: modTime,
+		Size:    fInfo.Size,
+		Content: content,
+		Meta:    meta,
+	}, nil
+}
+
+// List returns one page of directory entries and total count of entries matching the filter
+func (c *Static) List(reqPath string, pars *types.DirListParsSt) ([]*types.DirEntrySt, int64, error) {
+	if pars.Page < 0 {
+		return nil, 0, errs.BadPage
+	}
+
+	if pars.PageSize <= 0 {
+		return nil, 0, dopErrs.IncorrectPageSize
+	}
+
+	dirPath := strings.Trim(path.Clean("/"+reqPath), "/")
+
+	if c.isReservedPath(dirPath) {
+		return nil, 0, errs.BadPath
+	}
+
+	fInfo, err := c.r.storage.Stat(dirPath)
+	if err == nil && !fInfo.IsDir {
+		return nil, 0, errs.BadPath
+	}
+
+	items, err := c.r.storage.List(dirPath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]*types.DirEntrySt, 0, len(items))
+
+	for _, item := range items {
+		if strings.HasPrefix(item.Name, ".") || c.isReservedPath(path.Join(dirPath, item.Name)) {
+			continue
+		}
+
+		if pars.Prefix != "" && !strings.HasPrefix(item.Name, pars.Prefix) {
+			continue
+		}
+
+		entry := &types.DirEntrySt{
+			Name:    item.Name,
+			Path:    util.ToUrlPath(filepath.Join(util.ToFsPath(dirPath), item.Name)),
+			Size:    item.Size,
+			ModTime: item.ModTime,
+			IsDir:   item.IsDir,
+		}
+
+		if item.IsDir {
+			entry.IsZipDir = strings.HasPrefix(item.Name, cns.ZipDirNamePrefix)
+			if entry.IsZipDir {
+				entry.Path += "/"
+			}
+		} else {
+			entry.ContentType = mime.TypeByExtension(strings.ToLower(path.Ext(item.Name)))
+
+			_, blobInfo, err := c.r.Dedup.Resolve(path.Join(dirPath, item.Name), item)
+			if err == nil {
+				entry.Size = blobInfo.Size
+			}
+		}
+
+		result = append(result, entry)
+	}
+
+	totalCount := int64(len(result))
+
+	// page is compared before multiplying, so huge values do not overflow
+	offset := totalCount
+	if pars.Page <= totalCount/pars.PageSize {
+		offset = pars.Page * pars.PageSize
+	}
+
+	limit := totalCount
+	if pars.PageSize < totalCount-offset {
+		limit = offset + pars.PageSize
+	}
+
+	return result[offset:limit], totalCount, nil
+}
+
+// Remove removes a single file or a whole zip-directory
+func (c *Static) Remove(reqPath string) error {
+	filePath := strings.Trim(path.Clean("/"+reqPath), "/")
+
+	if filePath == "" || c.isReservedPath(filePath) {
+		return errs.BadPath
+	}
+
+	fInfo, err := c.r.storage.Stat(filePath)
+	if err != nil {
+		return dopErrs.ObjectNotFound
+	}
+
+	// only whole zip-directory can be removed, not a file inside of it
+	dirPath := path.Dir(filePath)
+	if strings.Contains("/"+dirPath, "/"+cns.ZipDirNamePrefix) {
+		return errs.BadPath
+	}
+
+	if fInfo.IsDir && !strings.HasPrefix(fInfo.Name, cns.ZipDirNamePrefix) {
+		return errs.BadPath
+	}
+
+	linked, err := c.r.Dedup.Remove(filePath, fInfo)
+	if err != nil {
+		return err
+	}
+
+	if !linked {
+		err = c.r.storage.Remove(filePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.r.Cache.RemoveForPath(filePath)
+	c.r.Derivative.RemoveForPath(filePath)
+
+	err = c.r.Meta.Remove(filePath)
+	if err != nil {
+		return err
+	}
+
+	c.pruneDateDirs(dirPath)
+
+	return nil
+}
+
+// pruneDateDirs removes empty directories created by util.GetDateUrlPath
+func (c *Static) pruneDateDirs(dirPath string) {
+	for i := 0; i < 3; i++ {
+		if dirPath == "." || dirPath == "" || !c.isDateDirName(path.Base(dirPath)) {
+			return
+		}
+
+		items, err := c.r.storage.List(dirPath)
+		if err != nil && err != dopErrs.ObjectNotFound {
+			return
+		}
+
+		if len(items) > 0 {
+			return
+		}
+
+		if err == nil {
+			err = c.r.storage.Remove(dirPath)
+			if err != nil && err != dopErrs.ObjectNotFound {
+				return
+			}
+		}
+
+		dirPath = path.Dir(dirPath)
+	}
+}
+
+func (c *Static) isDateDirName(v string) bool {
+	if v == "" {
+		return false
+	}
+
+	for _, ch := range v {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fitImg fits image into max sizes, returns true if file was rewritten
+func (c *Static) fitImg(filePath string) (bool, error) {
+	buffer := new(bytes.Buffer)
+
+	err := c.handleImg(filePath, filePath, buffer, &types.ImgParsSt{
+		Method: "fit",
+		Width:  c.r.imgMaxWidth,
+		Height: c.r.imgMaxHeight,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if buffer.Len() == 0 {
+		return false, nil
+	}
+
+	err = c.r.storage.Put(filePath, buffer)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// stripImgMeta removes EXIF, XMP and IPTC from the image, returns true if file was rewritten
+func (c *Static) stripImgMeta(filePath string) (bool, error) {
+	if _, ok := imgFileTypes[strings.ToLower(path.Ext(filePath))]; !ok {
+		return false, nil
+	}
+
+	release, err := c.r.Img.acquireDecode()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	fReader, err := c.r.storage.Get(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	buffer := new(bytes.Buffer)
+
+	err = c.r.Img.StripMeta(filePath, fReader, buffer)
+	fReader.Close()
+	if err != nil {
+		return false, err
+	}
+
+	if buffer.Len() == 0 {
+		return false, nil
+	}
+
+	err = c.r.storage.Put(filePath, buffer)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// imgDerivative returns transformed image, nil if the file is not an image or params do not change it
+func (c *Static) imgDerivative(
+	cKey string,
+	filePath string,
+	metaPath string,
+	name string,
+	modTime time.Time,
+	srcModTime time.Time,
+	pars *types.ImgParsSt,
+) (*cacheItemSt, error) {
+	if _, ok := imgFileTypes[strings.ToLower(path.Ext(name))]; !ok {
+		return nil, nil
+	}
+
+	// derivatives are kept in storage for every file served by its own path, files of zip-directories included,
+	// only index.html of zip-directory has no meta path, and it is never transformed
+	keepDerivative := metaPath != ""
+
+	var data []byte
+
+	if keepDerivative {
+		data = c.r.Derivative.Get(metaPath, srcModTime, pars)
+	}
+
+	if data == nil {
 		buffer := new(bytes.Buffer)
 
-		err = c.r.Img.Handle(absFsPath, buffer, imgPars)
+		err := c.handleImg(filePath, name, buffer, pars)
 		if err != nil {
-			return "", modTime, nil, err
+			return nil, err
+		}
+
+		if buffer.Len() == 0 {
+			return nil, nil
+		}
+
+		data = buffer.Bytes()
+
+		if keepDerivative {
+			c.r.Derivative.Set(metaPath, pars, data)
+		}
+	}
+
+	name = c.r.Img.FileName(name, pars)
+
+	c.r.Cache.Set(CachePoolImg, cKey, name, modTime, data, nil)
+
+	return &cacheItemSt{
+		name:    name,
+		modTime: modTime,
+		content: data,
+	}, nil
+}
+
+// handleImg transforms the image taking a decode slot, so it is limited both for requests and for uploads
+func (c *Static) handleImg(filePath string, fileName string, w io.Writer, pars *types.ImgParsSt) error {
+	if _, ok := imgFileTypes[strings.ToLower(path.Ext(fileName))]; !ok {
+		return nil
+	}
+
+	release, err := c.r.Img.acquireDecode()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	fReader, err := c.r.storage.Get(filePath)
+	if err != nil {
+		return err
+	}
+	defer fReader.Close()
+
+	return c.r.Img.Handle(fileName, fReader, w, pars)
+}
+
+// detectContentType sniffs content type of the stored file, also returns its size
+func (c *Static) detectContentType(filePath string) (string, int64, error) {
+	fInfo, err := c.r.storage.Stat(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+
+	contentPath, fInfo, err := c.r.Dedup.Resolve(filePath, fInfo)
+	if err != nil {
+		return "", 0, err
+	}
+
+	fReader, err := c.r.storage.Get(contentPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer fReader.Close()
+
+	head := make([]byte, 512)
+
+	n, err := io.ReadFull(fReader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		c.r.lg.Errorw("Fail to read file", err, "f_path", filePath)
+		return "", 0, err
+	}
+
+	result := http.DetectContentType(head[:n])
+
+	if result == "application/octet-stream" {
+		if extType := mime.TypeByExtension(strings.ToLower(path.Ext(filePath))); extType != "" {
+			result = extType
+		}
+	}
+
+	return result, fInfo.Size, nil
+}
+
+func (c *Static) copyFile(filePath string, w io.Writer) error {
+	fReader, err := c.r.storage.Get(filePath)
+	if err != nil {
+		return err
+	}
+	defer fReader.Close()
+
+	_, err = io.Copy(w, fReader)
+	if err != nil {
+		c.r.lg.Errorw("Fail to read file", err, "f_path", filePath)
+		return err
+	}
+
+	return nil
+}
+
+// isReservedPath checks if path points to service areas, that are not accessible through static api
+func (c *Static) isReservedPath(p string) bool {
+	p = "/" + strings.TrimPrefix(p, "/")
+
+	for _, dirName := range []string{cns.DedupDirName, cns.MetaDirName, cns.UploadDirName, cns.DerivativeDirName} {
+		if strings.HasPrefix(p+"/", "/"+dirName+"/") {
+			return true
 		}
+	}
+
+	return strings.HasPrefix(p, "/"+cns.KvsDirNamePrefix)
+}
 
-		if buffer.Len() > 0 {
-			content = buffer.Bytes()
+// isWMarkPath checks if file is inside of one of watermark directories
+func (c *Static) isWMarkPath(filePath string) bool {
+	fsPath := util.ToFsPath(filePath)
+
+	for _, p := range c.r.wMarkDirPaths {
+		if strings.HasPrefix(fsPath, p) {
+			return true
 		}
 	}
 
-	if len(content) == 0 {
-		content, err = ioutil.ReadFile(absFsPath)
+	return false
+}
+
+func (c *Static) newName(dirPath string, prefix string, ext string) (string, error) {
+	rndBytes := make([]byte, 8)
+
+	for {
+		_, err := rand.Read(rndBytes)
 		if err != nil {
-			c.r.lg.Errorw("Fail to read file", err, "f_path", absFsPath)
-			return "", modTime, nil, err
+			c.r.lg.Errorw("Fail to generate random name", err)
+			return "", err
+		}
+
+		result := path.Join(dirPath, prefix+hex.EncodeToString(rndBytes)+ext)
+
+		_, err = c.r.storage.Stat(result)
+		if err == dopErrs.ObjectNotFound {
+			return result, nil
+		}
+		if err != nil {
+			return "", err
 		}
 	}
+}
 
-	c.r.Cache.Set(cKey, name, modTime, content)
+func (c *Static) bufferedFile(name string, modTime time.Time, content []byte) *types.StaticFileSt {
+	return &types.StaticFileSt{
+		Name:    name,
+		ModTime: modTime,
+		Size:    int64(len(content)),
+		Content: readSeekNopCloser{bytes.NewReader(content)},
+	}
+}
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+type countWriter struct {
+	n int64
+}
 
-	return name, modTime, content, nil
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
 }
@@ -0,0 +1,336 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rendau/dop/dopErrs"
+
+	"github.com/rendau/kazan/internal/cns"
+	"github.com/rendau/kazan/internal/domain/errs"
+	"github.com/rendau/kazan/internal/domain/types"
+	"github.com/rendau/kazan/internal/domain/util"
+)
+
+// UploadOptionsSt is how uploaded files are stored.
+// Dedup stores equal contents once, Expiration is lifetime of unfinished resumable upload, MaxSize of zero is no limit.
+type UploadOptionsSt struct {
+	Dedup      bool
+	Expiration time.Duration
+	MaxSize    int64
+}
+
+// Upload implements resumable uploads, chunks are kept in staging area until upload is completed
+type Upload struct {
+	r *St
+
+	locks sync.Map
+}
+
+func NewUpload(r *St) *Upload {
+	return &Upload{
+		r: r,
+	}
+}
+
+func (c *Upload) MaxSize() int64 {
+	return c.r.uploadOpts.MaxSize
+}
+
+func (c *Upload) Create(obj *types.UploadSt) (*types.UploadSt, error) {
+	if obj.Length < 0 || (c.r.uploadOpts.MaxSize > 0 && obj.Length > c.r.uploadOpts.MaxSize) {
+		return nil, errs.UploadTooLarge
+	}
+
+	if obj.Dir == "" || obj.FileName == "" {
+		return nil, errs.BadFormData
+	}
+
+	err := c.r.Static.checkDir(util.ToUrlPath(obj.Dir))
+	if err != nil {
+		return nil, err
+	}
+
+	rndBytes := make([]byte, 16)
+
+	_, err = rand.Read(rndBytes)
+	if err != nil {
+		c.r.lg.Errorw("Fail to generate upload id", err)
+		return nil, err
+	}
+
+	now := time.Now()
+
+	obj.Id = hex.EncodeToString(rndBytes)
+	obj.Offset = 0
+	obj.CreatedAt = now
+	obj.ExpiresAt = now.Add(c.r.uploadOpts.Expiration)
+	obj.ResultPath = ""
+
+	err = c.saveInfo(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.Length == 0 {
+		return c.complete(obj)
+	}
+
+	return obj, nil
+}
+
+func (c *Upload) Get(id string) (*types.UploadSt, error) {
+	if !c.isValidId(id) {
+		return nil, dopErrs.ObjectNotFound
+	}
+
+	fReader, err := c.r.storage.Get(c.infoPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer fReader.Close()
+
+	result := &types.UploadSt{}
+
+	err = json.NewDecoder(fReader).Decode(result)
+	if err != nil {
+		c.r.lg.Errorw("Fail to decode upload info", err, "id", id)
+		return nil, err
+	}
+
+	if time.Now().After(result.ExpiresAt) {
+		return nil, dopErrs.ObjectNotFound
+	}
+
+	return result, nil
+}
+
+// Append writes next chunk of the upload, completes the upload when all data received
+func (c *Upload) Append(id string, offset int64, src io.Reader) (*types.UploadSt, error) {
+	lock := c.lock(id)
+	if !lock.TryLock() {
+		return nil, errs.UploadLocked
+	}
+	defer lock.Unlock()
+
+	obj, err := c.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.IsCompleted() || offset != obj.Offset {
+		return nil, errs.BadUploadOffset
+	}
+
+	counter := &countWriter{}
+
+	err = c.r.storage.Put(
+		c.chunkPath(id, offset),
+		io.TeeReader(io.LimitReader(src, obj.Length-obj.Offset), counter),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if counter.n == 0 {
+		_ = c.r.storage.Remove(c.chunkPath(id, offset))
+		return obj, nil
+	}
+
+	obj.Offset += counter.n
+
+	if obj.Offset < obj.Length {
+		err = c.saveInfo(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		return obj, nil
+	}
+
+	// the final offset is saved only together with the result,
+	// so if completion fails, the last chunk can be sent again
+	return c.complete(obj)
+}
+
+func (c *Upload) Remove(id string) error {
+	lock := c.lock(id)
+	if !lock.TryLock() {
+		return errs.UploadLocked
+	}
+	defer lock.Unlock()
+
+	_, err := c.Get(id)
+	if err != nil {
+		return err
+	}
+
+	c.locks.Delete(id)
+
+	return c.r.storage.Remove(c.dirPath(id))
+}
+
+// RemoveExpired removes staging data of expired uploads
+func (c *Upload) RemoveExpired() {
+	items, err := c.r.storage.List(cns.UploadDirName)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+
+	for _, item := range items {
+		if !item.IsDir || !c.isValidId(item.Name) {
+			continue
+		}
+
+		fReader, err := c.r.storage.Get(c.infoPath(item.Name))
+		if err != nil {
+			continue
+		}
+
+		obj := &types.UploadSt{}
+		err = json.NewDecoder(fReader).Decode(obj)
+		_ = fReader.Close()
+		if err == nil && now.Before(obj.ExpiresAt) {
+			continue
+		}
+
+		c.locks.Delete(item.Name)
+
+		err = c.r.storage.Remove(c.dirPath(item.Name))
+		if err != nil && err != dopErrs.ObjectNotFound {
+			c.r.lg.Warnw("Fail to remove expired upload", "id", item.Name, "error", err)
+		}
+	}
+}
+
+func (c *Upload) complete(obj *types.UploadSt) (*types.UploadSt, error) {
+	chunks, err := c.r.storage.List(c.chunksDirPath(obj.Id))
+	if err != nil && err != dopErrs.ObjectNotFound {
+		return nil, err
+	}
+
+	chunkPaths := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		chunkPaths = append(chunkPaths, path.Join(c.chunksDirPath(obj.Id), chunk.Name))
+	}
+	sort.Strings(chunkPaths)
+
+	src := &chunksReader{r: c.r, paths: chunkPaths}
+	defer src.Close()
+
+	obj.ResultPath, err = c.r.Static.Create(obj.Dir, obj.FileName, src, obj.NoCut, obj.ExtractZip, obj.StripMeta, obj.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.saveInfo(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.r.storage.Remove(c.chunksDirPath(obj.Id))
+	if err != nil && err != dopErrs.ObjectNotFound {
+		c.r.lg.Warnw("Fail to remove upload chunks", "id", obj.Id, "error", err)
+	}
+
+	return obj, nil
+}
+
+func (c *Upload) saveInfo(obj *types.UploadSt) error {
+	dataRaw, err := json.Marshal(obj)
+	if err != nil {
+		c.r.lg.Errorw("Fail to marshal upload info", err)
+		return err
+	}
+
+	return c.r.storage.Put(c.infoPath(obj.Id), bytes.NewReader(dataRaw))
+}
+
+func (c *Upload) lock(id string) *sync.Mutex {
+	lock, _ := c.locks.LoadOrStore(id, &sync.Mutex{})
+
+	return lock.(*sync.Mutex)
+}
+
+func (c *Upload) isValidId(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+
+	return strings.Trim(id, "0123456789abcdef") == ""
+}
+
+func (c *Upload) dirPath(id string) string {
+	return path.Join(cns.UploadDirName, id)
+}
+
+func (c *Upload) infoPath(id string) string {
+	return path.Join(c.dirPath(id), "info.json")
+}
+
+func (c *Upload) chunksDirPath(id string) string {
+	return path.Join(c.dirPath(id), "chunks")
+}
+
+func (c *Upload) chunkPath(id string, offset int64) string {
+	return path.Join(c.chunksDirPath(id), fmt.Sprintf("%020d", offset))
+}
+
+// chunksReader reads chunks one after another, opening each one only when it is needed
+type chunksReader struct {
+	r *St
+
+	paths []string
+	cur   io.ReadCloser
+}
+
+func (o *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if o.cur == nil {
+			if len(o.paths) == 0 {
+				return 0, io.EOF
+			}
+
+			cur, err := o.r.storage.Get(o.paths[0])
+			if err != nil {
+				return 0, err
+			}
+
+			o.cur = cur
+			o.paths = o.paths[1:]
+		}
+
+		n, err := o.cur.Read(p)
+		if err == io.EOF {
+			_ = o.cur.Close()
+			o.cur = nil
+
+			if n > 0 {
+				return n, nil
+			}
+
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (o *chunksReader) Close() error {
+	if o.cur != nil {
+		return o.cur.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/rendau/kazan/internal/domain/errs"
+)
+
+var (
+	// files of these types are already compressed, deflating them again only wastes cpu
+	zipCompressedFileExts = map[string]struct{}{
+		".jpg":   {},
+		".jpeg":  {},
+		".png":   {},
+		".gif":   {},
+		".webp":  {},
+		".avif":  {},
+		".mp3":   {},
+		".mp4":   {},
+		".mov":   {},
+		".webm":  {},
+		".zip":   {},
+		".gz":    {},
+		".rar":   {},
+		".7z":    {},
+		".woff":  {},
+		".woff2": {},
+	}
+)
+
+// ZipOptionsSt is compression of archives made for download.
+// CompressionLevel is of compress/flate, StoreCompressed keeps already compressed files (images, video, ...) as is.
+type ZipOptionsSt struct {
+	CompressionLevel int
+	StoreCompressed  bool
+}
+
+type Zip struct {
+	r *St
+}
+
+func NewZip(r *St) *Zip {
+	return &Zip{
+		r: r,
+	}
+}
+
+func (c *Zip) Extract(src io.Reader, dirPath string) error {
+	srcData, err := io.ReadAll(src)
+	if err != nil {
+		c.r.lg.Errorw("Fail to read zip data", err)
+		return err
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(srcData), int64(len(srcData)))
+	if err != nil {
+		return errs.BadFile
+	}
+
+	files := make([]*zip.File, 0, len(reader.File))
+
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		files = append(files, f)
+	}
+
+	rootPrefix := c.commonRootDir(files)
+
+	for _, f := range files {
+		fPath := strings.TrimPrefix(path.Clean("/"+f.Name), "/")
+		fPath = strings.TrimPrefix(fPath, rootPrefix)
+
+		err = func() error {
+			fReader, err := f.Open()
+			if err != nil {
+				c.r.lg.Errorw("Fail to open zip file", err, "name", f.Name)
+				return errs.BadFile
+			}
+			defer fReader.Close()
+
+			return c.r.storage.Put(path.Join(dirPath, fPath), fReader)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CompressDir writes archive of the directory into w while it is being produced
+func (c *Zip) CompressDir(w io.Writer, dirPath string) error {
+	zipWriter := zip.NewWriter(w)
+
+	if c.r.zipOpts.CompressionLevel != flate.DefaultCompression {
+		zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, c.r.zipOpts.CompressionLevel)
+		})
+	}
+
+	err := c.compressDir(zipWriter, dirPath, "")
+	if err != nil {
+		return err
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		c.r.lg.Errorw("Fail to close zip writer", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *Zip) compressDir(zipWriter *zip.Writer, dirPath string, relPath string) error {
+	items, err := c.r.storage.List(path.Join(dirPath, relPath))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		itemRelPath := path.Join(relPath, item.Name)
+
+		if item.IsDir {
+			err = c.compressDir(zipWriter, dirPath, itemRelPath)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err = func() error {
+			fReader, err := c.r.storage.Get(path.Join(dirPath, itemRelPath))
+			if err != nil {
+				return err
+			}
+			defer fReader.Close()
+
+			fWriter, err := zipWriter.CreateHeader(&zip.FileHeader{
+				Name:     itemRelPath,
+				Method:   c.compressMethod(item.Name),
+				Modified: item.ModTime,
+			})
+			if err != nil {
+				c.r.lg.Errorw("Fail to create zip entry", err)
+				return err
+			}
+
+			_, err = io.Copy(fWriter, fReader)
+			if err != nil {
+				c.r.lg.Errorw("Fail to copy data", err)
+				return err
+			}
+
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Zip) compressMethod(fileName string) uint16 {
+	if c.r.zipOpts.CompressionLevel == flate.NoCompression {
+		return zip.Store
+	}
+
+	if c.r.zipOpts.StoreCompressed {
+		if _, ok := zipCompressedFileExts[strings.ToLower(path.Ext(fileName))]; ok {
+			return zip.Store
+		}
+	}
+
+	return zip.Deflate
+}
+
+// commonRootDir returns "<dir>/" if all files are placed in one root directory
+func (c *Zip) commonRootDir(files []*zip.File) string {
+	result := ""
+
+	for _, f := range files {
+		fPath := strings.TrimPrefix(path.Clean("/"+f.Name), "/")
+
+		rootDir, _, found := strings.Cut(fPath, "/")
+		if !found {
+			return ""
+		}
+
+		if result == "" {
+			result = rootDir
+		} else if result != rootDir {
+			return ""
+		}
+	}
+
+	if result == "" {
+		return ""
+	}
+
+	return result + "/"
+}
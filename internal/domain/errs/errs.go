@@ -7,4 +7,23 @@ import (
 const (
 	BadFormData = dopErrs.Err("bad_form_data")
 	BadFile     = dopErrs.Err("bad_file")
+	BadPath     = dopErrs.Err("bad_path")
+	BadPage     = dopErrs.Err("bad_page")
+
+	BadImgFormat = dopErrs.Err("bad_img_format")
+	BadImgFrame  = dopErrs.Err("bad_img_frame")
+	BadImgParams = dopErrs.Err("bad_img_params")
+	BadImgPreset = dopErrs.Err("bad_img_preset")
+	NotImg       = dopErrs.Err("not_img")
+
+	ImgParamsNotAllowed = dopErrs.Err("img_params_not_allowed")
+	ImgBusy             = dopErrs.Err("img_busy")
+	ImgTooLarge         = dopErrs.Err("img_too_large")
+
+	BadSignature     = dopErrs.Err("bad_signature")
+	SignatureExpired = dopErrs.Err("signature_expired")
+
+	UploadTooLarge  = dopErrs.Err("upload_too_large")
+	UploadLocked    = dopErrs.Err("upload_locked")
+	BadUploadOffset = dopErrs.Err("bad_upload_offset")
 )
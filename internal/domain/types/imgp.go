@@ -2,6 +2,8 @@ package types
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 )
 
 type ImgParsSt struct {
@@ -10,6 +12,32 @@ type ImgParsSt struct {
 	Height    int
 	Blur      float64
 	Grayscale bool
+	Gravity   string
+	WMark     bool
+	Dpr       float64 // multiplier of Width and Height
+
+	Rotate     float64 // degrees clockwise
+	Background string  // hex color of the area uncovered by rotation
+	FlipH      bool
+	FlipV      bool
+	Sharpen    float64
+	Brightness float64
+	Contrast   float64
+	Gamma      float64
+	Saturation float64
+	Invert     bool
+
+	Format string
+	Frame  *int
+
+	// region for "crop" method
+	CropX int
+	CropY int
+	CropW int
+	CropH int
+
+	Quality        int
+	PngCompression string
 }
 
 func (o *ImgParsSt) Reset() {
@@ -18,6 +46,27 @@ func (o *ImgParsSt) Reset() {
 	o.Height = 0
 	o.Blur = 0
 	o.Grayscale = false
+	o.Gravity = ""
+	o.WMark = false
+	o.Dpr = 0
+	o.Rotate = 0
+	o.Background = ""
+	o.FlipH = false
+	o.FlipV = false
+	o.Sharpen = 0
+	o.Brightness = 0
+	o.Contrast = 0
+	o.Gamma = 0
+	o.Saturation = 0
+	o.Invert = false
+	o.Format = ""
+	o.Frame = nil
+	o.CropX = 0
+	o.CropY = 0
+	o.CropW = 0
+	o.CropH = 0
+	o.Quality = 0
+	o.PngCompression = ""
 }
 
 func (o *ImgParsSt) IsEmpty() bool {
@@ -25,5 +74,48 @@ func (o *ImgParsSt) IsEmpty() bool {
 }
 
 func (o *ImgParsSt) String() string {
-	return fmt.Sprintf("m=%s&w=%d&h=%d&blur=%fgrayscale=%v", o.Method, o.Width, o.Height, o.Blur, o.Grayscale)
+	frame := ""
+	if o.Frame != nil {
+		frame = strconv.Itoa(*o.Frame)
+	}
+
+	return fmt.Sprintf(
+		"m=%s&w=%d&h=%d&blur=%fgrayscale=%v&g=%s&f=%s&frame=%s&crop=%d,%d,%d,%d&q=%d&png_c=%s"+
+			"&rot=%f&bg=%s&flip_h=%v&flip_v=%v&sharpen=%f&brightness=%f&contrast=%f&gamma=%f&saturation=%f&invert=%v&wmark=%v&dpr=%f",
+		o.Method, o.Width, o.Height, o.Blur, o.Grayscale, o.Gravity, o.Format, frame,
+		o.CropX, o.CropY, o.CropW, o.CropH,
+		o.Quality, o.PngCompression,
+		o.Rotate, o.Background, o.FlipH, o.FlipV, o.Sharpen, o.Brightness, o.Contrast, o.Gamma, o.Saturation, o.Invert, o.WMark, o.Dpr,
+	)
+}
+
+type ImgInfoSt struct {
+	// Width and Height are given after EXIF orientation is applied
+	Width         int        `json:"width"`
+	Height        int        `json:"height"`
+	Format        string     `json:"format"`
+	ColorModel    string     `json:"color_model"`
+	Size          int64      `json:"size"`
+	Frames        int        `json:"frames"`
+	DominantColor string     `json:"dominant_color"` // hex rrggbb
+	Exif          *ImgExifSt `json:"exif"`
+}
+
+type ImgExifSt struct {
+	CapturedAt *time.Time `json:"captured_at"`
+	Make       string     `json:"make"`
+	Model      string     `json:"model"`
+	Lat        *float64   `json:"lat"`
+	Lon        *float64   `json:"lon"`
+}
+
+type ImgPlaceholderSt struct {
+	BlurHash string `json:"blur_hash"`
+	Lqip     string `json:"lqip"` // data-url of tiny image
+}
+
+// SrcsetItemSt is candidate of srcset: preset with pixel ratio Dpr, or image of Width
+type SrcsetItemSt struct {
+	Width int
+	Dpr   float64
 }
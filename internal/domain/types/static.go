@@ -0,0 +1,67 @@
+package types
+
+import (
+	"io"
+	"time"
+
+	"github.com/rendau/dop/dopTypes"
+)
+
+type StaticFileSt struct {
+	Name    string
+	ModTime time.Time
+	Size    int64
+	Content io.ReadSeekCloser
+
+	// Meta is set only for original (not transformed) content
+	Meta *StaticMetaSt
+
+	// Stream is set instead of Content for generated content, that can not be seeked (e.g. zip archive)
+	Stream func(w io.Writer) error
+}
+
+func (o *StaticFileSt) Close() error {
+	if o.Content != nil {
+		return o.Content.Close()
+	}
+
+	return nil
+}
+
+type DirListParsSt struct {
+	dopTypes.ListParams
+
+	Prefix string
+}
+
+type DirEntrySt struct {
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ContentType string    `json:"content_type"`
+	IsDir       bool      `json:"is_dir"`
+	IsZipDir    bool      `json:"is_zip_dir"`
+}
+
+type StaticMetaSt struct {
+	OriginalName string    `json:"original_name"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Sha256       string    `json:"sha256"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+	Tags         []string  `json:"tags"`
+
+	// placeholder of image, computed on request
+	BlurHash string `json:"blur_hash,omitempty"`
+	Lqip     string `json:"lqip,omitempty"`
+}
+
+type CacheStatsSt struct {
+	Budget    int64  `json:"budget"`
+	Size      int64  `json:"size"`
+	Count     int    `json:"count"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
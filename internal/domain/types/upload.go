@@ -0,0 +1,24 @@
+package types
+
+import (
+	"time"
+)
+
+type UploadSt struct {
+	Id         string    `json:"id"`
+	Length     int64     `json:"length"`
+	Offset     int64     `json:"offset"`
+	Dir        string    `json:"dir"`
+	FileName   string    `json:"file_name"`
+	NoCut      bool      `json:"no_cut"`
+	ExtractZip bool      `json:"extract_zip"`
+	StripMeta  *bool     `json:"strip_meta"`
+	Tags       []string  `json:"tags"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	ResultPath string    `json:"result_path"`
+}
+
+func (o *UploadSt) IsCompleted() bool {
+	return o.ResultPath != ""
+}
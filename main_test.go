@@ -3,19 +3,35 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
 	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/disintegration/imaging"
-	cleanerMock "github.com/rendau/kazan/internal/adapters/cleaner/mock"
-	"github.com/rendau/kazan/internal/adapters/logger/zap"
+	dopLoggerZap "github.com/rendau/dop/adapters/logger/zap"
+	"github.com/rendau/dop/dopErrs"
+	"github.com/rendau/dop/dopTools"
+	"github.com/rendau/dop/dopTypes"
+	storageMock "github.com/rendau/kazan/internal/adapters/storage/mock"
 	"github.com/rendau/kazan/internal/cns"
 	"github.com/rendau/kazan/internal/domain/core"
 	"github.com/rendau/kazan/internal/domain/errs"
@@ -26,296 +42,2023 @@ import (
 )
 
 const confPath = "test_conf.yml"
-const testDirPath = "test_dir"
 const imgMaxWidth = 1000
 const imgMaxHeight = 1000
 
+// zipOpts are defaults of the service
+var zipOpts = core.ZipOptionsSt{CompressionLevel: flate.DefaultCompression, StoreCompressed: true}
+
 type fsItemSt struct {
 	p  string
 	c  string
 	mt time.Time
 }
 
+// gatedStorage holds reads of gatePath until gate is closed, reads of panicPath panic
+type gatedStorage struct {
+	*storageMock.St
+
+	gatePath  string
+	gate      chan struct{}
+	entered   chan struct{}
+	reads     int32
+	panicPath string
+}
+
+func (s *gatedStorage) Get(p string) (io.ReadSeekCloser, error) {
+	if s.panicPath != "" && p == s.panicPath {
+		panic("read of " + p)
+	}
+
+	if s.gatePath != "" && p == s.gatePath {
+		if atomic.AddInt32(&s.reads, 1) == 1 {
+			close(s.entered)
+		}
+		<-s.gate
+	}
+
+	return s.St.Get(p)
+}
+
 var (
 	app = struct {
-		lg      *zap.St
-		cleaner *cleanerMock.St
+		lg      *dopLoggerZap.St
+		storage *storageMock.St
 		core    *core.St
 	}{}
 )
 
 func cleanTestDir() {
-	err := filepath.Walk(testDirPath, func(p string, info os.FileInfo, err error) error {
-		if err != nil || info == nil {
-			return nil
-		}
+	app.storage.Clean()
+}
 
-		if p == testDirPath {
-			return nil
+func TestMain(m *testing.M) {
+	viper.SetConfigFile(confPath)
+	_ = viper.ReadInConfig()
+
+	viper.AutomaticEnv()
+
+	app.lg = dopLoggerZap.New("info", true)
+
+	app.storage = storageMock.New()
+
+	app.core = core.New(
+		app.lg,
+		app.storage,
+		imgMaxWidth,
+		imgMaxHeight,
+		core.ImgOptionsSt{},
+		core.CacheOptionsSt{RawSize: 1 << 20, ImgSize: 1 << 20, MaxObjectSize: 1 << 19},
+		zipOpts,
+		core.UploadOptionsSt{Expiration: time.Hour},
+		true,
+	)
+
+	// Start tests
+	code := m.Run()
+
+	// cleanTestDir()
+
+	os.Exit(code)
+}
+
+func TestCreate(t *testing.T) {
+	cleanTestDir()
+
+	_, err := app.core.Static.Create("asd/"+cns.ZipDirNamePrefix+"_asd", "a.txt", bytes.NewBuffer([]byte("test_data")), false, false, nil, nil)
+	require.NotNil(t, err)
+	require.Equal(t, errs.BadDirName, err)
+
+	_, err = app.core.Static.Create(cns.ZipDirNamePrefix+"_asd/asd", "a.txt", bytes.NewBuffer([]byte("test_data")), false, false, nil, nil)
+	require.NotNil(t, err)
+	require.Equal(t, errs.BadDirName, err)
+
+	fPath, err := app.core.Static.Create("photos", "data.txt", bytes.NewBuffer([]byte("test_data")), false, false, nil, nil)
+	require.Nil(t, err)
+
+	fPathPrefix := "photos/" + time.Now().Format("2006/01/02") + "/"
+
+	require.True(t, strings.HasPrefix(fPath, fPathPrefix))
+	require.False(t, strings.Contains(strings.TrimPrefix(fPath, fPathPrefix), "/"))
+
+	fName, _, fContent, err := getStatic(fPath, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+	require.NotNil(t, fContent)
+	require.Equal(t, "test_data", string(fContent))
+	require.NotEmpty(t, fName)
+
+	largeImg := imaging.New(imgMaxWidth+10, imgMaxHeight+10, color.RGBA{R: 0xaa, G: 0x00, B: 0x00, A: 0xff})
+	require.NotNil(t, largeImg)
+
+	largeImgBuffer := new(bytes.Buffer)
+
+	err = imaging.Encode(largeImgBuffer, largeImg, imaging.JPEG)
+	require.Nil(t, err)
+
+	fPath, err = app.core.Static.Create("photos", "a.jpg", largeImgBuffer, true, false, nil, nil)
+	require.Nil(t, err)
+
+	_, _, fContent, err = getStatic(fPath, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+	require.NotNil(t, fContent)
+
+	img, err := imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+
+	imgBounds := img.Bounds().Max
+	require.Equal(t, imgMaxWidth+10, imgBounds.X)
+	require.Equal(t, imgMaxHeight+10, imgBounds.X)
+
+	largeImg = imaging.New(imgMaxWidth+10, imgMaxHeight+10, color.RGBA{R: 0xaa, G: 0x00, B: 0x00, A: 0xff})
+	require.NotNil(t, largeImg)
+
+	largeImgBuffer = new(bytes.Buffer)
+
+	err = imaging.Encode(largeImgBuffer, largeImg, imaging.JPEG)
+	require.Nil(t, err)
+
+	fPath, err = app.core.Static.Create("photos", "a.jpg", largeImgBuffer, false, false, nil, nil)
+	require.Nil(t, err)
+
+	_, _, fContent, err = getStatic(fPath, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+	require.NotNil(t, fContent)
+
+	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+
+	imgBounds = img.Bounds().Max
+	require.Equal(t, imgMaxWidth, imgBounds.X)
+	require.Equal(t, imgMaxHeight, imgBounds.X)
+
+	_, _, fContent, err = getStatic(fPath, &types.ImgParsSt{Method: "fit", Width: imgMaxWidth - 10, Height: imgMaxHeight - 10}, false)
+	require.Nil(t, err)
+	require.NotNil(t, fContent)
+
+	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+
+	imgBounds = img.Bounds().Max
+	require.Equal(t, imgMaxWidth-10, imgBounds.X)
+	require.Equal(t, imgMaxHeight-10, imgBounds.X)
+
+	_, _, fContent, err = getStatic(fPath, &types.ImgParsSt{Method: "fit", Width: imgMaxWidth + 10, Height: imgMaxHeight + 10}, false)
+	require.Nil(t, err)
+	require.NotNil(t, fContent)
+
+	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+
+	imgBounds = img.Bounds().Max
+	require.Equal(t, imgMaxWidth, imgBounds.X)
+	require.Equal(t, imgMaxHeight, imgBounds.X)
+}
+
+func TestCreateZip(t *testing.T) {
+	cleanTestDir()
+
+	zipContentIsSame := func(a, b []fsItemSt) {
+		require.Equal(t, len(a), len(b))
+
+		for _, ai := range a {
+			found := false
+
+			for _, bi := range b {
+				if bi.p == ai.p {
+					found = true
+					require.Equal(t, ai.c, bi.c)
+					break
+				}
+			}
+
+			require.True(t, found)
 		}
 
-		// app.lg.Infow("cleanTestDir walk", "path", p, "name", info.Name(), "mt", info.ModTime())
+		for _, bi := range b {
+			found := false
 
-		if info.IsDir() {
-			err = os.RemoveAll(p)
-			if err != nil {
-				return err
+			for _, ai := range a {
+				if ai.p == bi.p {
+					found = true
+					require.Equal(t, bi.c, ai.c)
+					break
+				}
 			}
 
-			return filepath.SkipDir
-		}
+			require.True(t, found)
+		}
+	}
+
+	srcZipFiles := []fsItemSt{
+		{p: "index.html", c: "some html content"},
+		{p: "abc/file.txt", c: "file content"},
+		{p: "abc/qwe/x.txt", c: "x content"},
+		{p: "todo.txt", c: "todo content"},
+	}
+
+	zipBuffer, err := createZipArchive(srcZipFiles)
+	require.Nil(t, err)
+
+	_, err = app.core.Static.Create("zip/"+cns.ZipDirNamePrefix+"_asd", "a.zip", zipBuffer, false, true, nil, nil)
+	require.NotNil(t, err)
+	require.Equal(t, errs.BadDirName, err)
+
+	_, err = app.core.Static.Create(cns.ZipDirNamePrefix+"_asd/zip", "a.zip", zipBuffer, false, true, nil, nil)
+	require.NotNil(t, err)
+	require.Equal(t, errs.BadDirName, err)
+
+	fPath, err := app.core.Static.Create("zip", "a.zip", zipBuffer, false, true, nil, nil)
+	require.Nil(t, err)
+	require.True(t, strings.HasSuffix(fPath, "/"))
+
+	for _, zp := range srcZipFiles {
+		_, _, fContent, err := getStatic(fPath+zp.p, &types.ImgParsSt{}, false)
+		require.Nil(t, err)
+		require.NotNil(t, fContent)
+		require.Equal(t, zp.c, string(fContent))
+	}
+
+	fName, _, fContent, err := getStatic(fPath, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+	require.Equal(t, "index.html", fName)
+	require.NotNil(t, fContent)
+	require.Equal(t, "some html content", string(fContent))
+
+	fName, _, fContent, err = getStatic(fPath, &types.ImgParsSt{}, true)
+	require.Nil(t, err)
+	require.True(t, strings.HasSuffix(fName, ".zip"))
+	require.NotNil(t, fContent)
+
+	resultZipFiles, err := extractZipArchive(fContent)
+	require.Nil(t, err)
+	zipContentIsSame(srcZipFiles, resultZipFiles)
+
+	srcZipFiles = []fsItemSt{
+		{p: "root/index.html", c: "some html content"},
+		{p: "root/abc/file.txt", c: "file content"},
+		{p: "root/abc/qwe/x.txt", c: "x content"},
+		{p: "root/todo.txt", c: "todo content"},
+	}
+
+	zipBuffer, err = createZipArchive(srcZipFiles)
+	require.Nil(t, err)
+
+	fPath, err = app.core.Static.Create("zip", "a.zip", zipBuffer, false, true, nil, nil)
+	require.Nil(t, err)
+	require.True(t, strings.HasSuffix(fPath, "/"))
+
+	for _, zp := range srcZipFiles {
+		_, _, fContent, err := getStatic(fPath+strings.TrimPrefix(zp.p, "root/"), &types.ImgParsSt{}, false)
+		require.Nil(t, err)
+		require.NotNil(t, fContent)
+		require.Equal(t, zp.c, string(fContent))
+	}
+
+	fName, _, fContent, err = getStatic(fPath, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+	require.Equal(t, "index.html", fName)
+	require.NotNil(t, fContent)
+	require.Equal(t, "some html content", string(fContent))
+}
+
+func TestMeta(t *testing.T) {
+	cleanTestDir()
+
+	fPath, err := app.core.Static.Create("docs", "Report 2024.txt", bytes.NewBuffer([]byte("test_data")), false, false, nil, []string{"report", "2024"})
+	require.Nil(t, err)
+
+	meta, err := app.core.Static.GetMeta(fPath)
+	require.Nil(t, err)
+	require.NotNil(t, meta)
+	require.Equal(t, "Report 2024.txt", meta.OriginalName)
+	require.Equal(t, "text/plain; charset=utf-8", meta.ContentType)
+	require.Equal(t, int64(9), meta.Size)
+	require.Equal(t, "e7d87b738825c33824cf3fd32b7314161fc8c425129163ff5e7260fc7288da36", meta.Sha256)
+	require.Equal(t, []string{"report", "2024"}, meta.Tags)
+	require.False(t, meta.UploadedAt.IsZero())
+
+	file, err := app.core.Static.Get(fPath, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+	require.Nil(t, file.Close())
+	require.NotNil(t, file.Meta)
+	require.Equal(t, "Report 2024.txt", file.Meta.OriginalName)
+
+	err = app.core.Static.Remove(fPath)
+	require.Nil(t, err)
+
+	_, err = app.core.Static.GetMeta(fPath)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+}
+
+func TestUpload(t *testing.T) {
+	cleanTestDir()
+
+	_, err := app.core.Upload.Create(&types.UploadSt{Length: 10, Dir: cns.ZipDirNamePrefix + "_asd", FileName: "a.txt"})
+	require.Equal(t, errs.BadDirName, err)
+
+	obj, err := app.core.Upload.Create(&types.UploadSt{Length: 10, Dir: "docs", FileName: "a.txt", Tags: []string{"x"}})
+	require.Nil(t, err)
+	require.NotEmpty(t, obj.Id)
+	require.Equal(t, int64(0), obj.Offset)
+
+	_, err = app.core.Upload.Append(obj.Id, 3, bytes.NewBufferString("0123"))
+	require.Equal(t, errs.BadUploadOffset, err)
+
+	obj, err = app.core.Upload.Append(obj.Id, 0, bytes.NewBufferString("0123"))
+	require.Nil(t, err)
+	require.Equal(t, int64(4), obj.Offset)
+	require.False(t, obj.IsCompleted())
+
+	obj, err = app.core.Upload.Get(obj.Id)
+	require.Nil(t, err)
+	require.Equal(t, int64(4), obj.Offset)
+
+	obj, err = app.core.Upload.Append(obj.Id, 4, bytes.NewBufferString("456789extra"))
+	require.Nil(t, err)
+	require.Equal(t, int64(10), obj.Offset)
+	require.True(t, obj.IsCompleted())
+	require.True(t, strings.HasPrefix(obj.ResultPath, "docs/"))
+
+	_, _, fContent, err := getStatic(obj.ResultPath, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+	require.Equal(t, "0123456789", string(fContent))
+
+	meta, err := app.core.Static.GetMeta(obj.ResultPath)
+	require.Nil(t, err)
+	require.Equal(t, "a.txt", meta.OriginalName)
+	require.Equal(t, []string{"x"}, meta.Tags)
+
+	_, err = app.core.Upload.Append(obj.Id, 10, bytes.NewBufferString("0"))
+	require.Equal(t, errs.BadUploadOffset, err)
+
+	err = app.core.Upload.Remove(obj.Id)
+	require.Nil(t, err)
+
+	_, err = app.core.Upload.Get(obj.Id)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	// failed completion keeps the last chunk pending
+	imgBuffer := new(bytes.Buffer)
+	err = imaging.Encode(imgBuffer, imaging.New(10, 10, color.White), imaging.PNG)
+	require.Nil(t, err)
+
+	obj, err = app.core.Upload.Create(&types.UploadSt{Length: int64(imgBuffer.Len()), Dir: "photos", FileName: "a.png"})
+	require.Nil(t, err)
+
+	_, err = app.core.Upload.Append(obj.Id, 0, bytes.NewReader(make([]byte, imgBuffer.Len())))
+	require.Equal(t, errs.NotImg, err)
+
+	obj, err = app.core.Upload.Get(obj.Id)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), obj.Offset)
+	require.False(t, obj.IsCompleted())
+
+	obj, err = app.core.Upload.Append(obj.Id, 0, imgBuffer)
+	require.Nil(t, err)
+	require.True(t, obj.IsCompleted())
+
+	_, _, fContent, err = getStatic(obj.ResultPath, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+
+	resImg, err := imaging.Decode(bytes.NewReader(fContent))
+	require.Nil(t, err)
+	require.Equal(t, 10, resImg.Bounds().Dx())
+
+	stg := storageMock.New()
+
+	cr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+	obj, err = cr.Upload.Create(&types.UploadSt{Length: 10, Dir: "docs", FileName: "a.txt"})
+	require.Nil(t, err)
+
+	_, err = cr.Upload.Append(obj.Id, 0, bytes.NewBufferString("0123"))
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	cr.Upload.RemoveExpired()
+
+	_, err = stg.Stat(cns.UploadDirName)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+}
+
+func TestRemove(t *testing.T) {
+	cleanTestDir()
+
+	fPath, err := app.core.Static.Create("docs", "data.txt", bytes.NewBuffer([]byte("test_data")), false, false, nil, nil)
+	require.Nil(t, err)
+
+	_, _, _, err = getStatic(fPath, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+
+	err = app.core.Static.Remove("docs")
+	require.Equal(t, errs.BadPath, err)
+
+	err = app.core.Static.Remove(fPath)
+	require.Nil(t, err)
+
+	_, _, _, err = getStatic(fPath, &types.ImgParsSt{}, false)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	err = app.core.Static.Remove(fPath)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	zipBuffer, err := createZipArchive([]fsItemSt{
+		{p: "index.html", c: "some html content"},
+		{p: "abc/file.txt", c: "file content"},
+	})
+	require.Nil(t, err)
+
+	fPath, err = app.core.Static.Create("zip", "a.zip", zipBuffer, false, true, nil, nil)
+	require.Nil(t, err)
+
+	err = app.core.Static.Remove(fPath + "abc/file.txt")
+	require.Equal(t, errs.BadPath, err)
+
+	err = app.core.Static.Remove(fPath)
+	require.Nil(t, err)
+
+	_, _, _, err = getStatic(fPath, &types.ImgParsSt{}, false)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+}
+
+func TestList(t *testing.T) {
+	cleanTestDir()
+
+	fPath1, err := app.core.Static.Create("docs", "a.txt", bytes.NewBuffer([]byte("a_data")), false, false, nil, nil)
+	require.Nil(t, err)
+
+	fPath2, err := app.core.Static.Create("docs", "b.pdf", bytes.NewBuffer([]byte("b_data")), false, false, nil, nil)
+	require.Nil(t, err)
+
+	zipBuffer, err := createZipArchive([]fsItemSt{{p: "index.html", c: "some html content"}})
+	require.Nil(t, err)
+
+	fPath3, err := app.core.Static.Create("docs", "a.zip", zipBuffer, false, true, nil, nil)
+	require.Nil(t, err)
+
+	dirPath := path.Dir(fPath1)
+
+	items, tCount, err := app.core.Static.List(dirPath, &types.DirListParsSt{ListParams: dopTypes.ListParams{PageSize: 10}})
+	require.Nil(t, err)
+	require.Equal(t, int64(3), tCount)
+	require.Len(t, items, 3)
+
+	for _, item := range items {
+		switch item.Path {
+		case fPath1:
+			require.Equal(t, int64(6), item.Size)
+			require.Equal(t, "text/plain; charset=utf-8", item.ContentType)
+		case fPath2:
+			require.Equal(t, "application/pdf", item.ContentType)
+		case fPath3:
+			require.True(t, item.IsDir)
+			require.True(t, item.IsZipDir)
+		default:
+			require.Fail(t, "unexpected item", item.Path)
+		}
+	}
+
+	items, tCount, err = app.core.Static.List(dirPath, &types.DirListParsSt{ListParams: dopTypes.ListParams{Page: 1, PageSize: 2}})
+	require.Nil(t, err)
+	require.Equal(t, int64(3), tCount)
+	require.Len(t, items, 1)
+
+	items, tCount, err = app.core.Static.List(dirPath, &types.DirListParsSt{ListParams: dopTypes.ListParams{PageSize: 10}, Prefix: cns.ZipDirNamePrefix})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), tCount)
+	require.Equal(t, fPath3, items[0].Path)
+
+	items, _, err = app.core.Static.List("docs", &types.DirListParsSt{ListParams: dopTypes.ListParams{PageSize: 10}})
+	require.Nil(t, err)
+	require.Len(t, items, 1)
+	require.True(t, items[0].IsDir)
+	require.False(t, items[0].IsZipDir)
+
+	_, _, err = app.core.Static.List(fPath1, &types.DirListParsSt{ListParams: dopTypes.ListParams{PageSize: 10}})
+	require.Equal(t, errs.BadPath, err)
+
+	_, _, err = app.core.Static.List("photos", &types.DirListParsSt{ListParams: dopTypes.ListParams{PageSize: 10}})
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	_, _, err = app.core.Static.List(dirPath, &types.DirListParsSt{ListParams: dopTypes.ListParams{Page: -1, PageSize: 10}})
+	require.Equal(t, errs.BadPage, err)
+
+	_, _, err = app.core.Static.List(dirPath, &types.DirListParsSt{ListParams: dopTypes.ListParams{PageSize: -1}})
+	require.Equal(t, dopErrs.IncorrectPageSize, err)
+
+	items, tCount, err = app.core.Static.List(dirPath, &types.DirListParsSt{ListParams: dopTypes.ListParams{Page: math.MaxInt64, PageSize: 2}})
+	require.Nil(t, err)
+	require.Equal(t, int64(3), tCount)
+	require.Len(t, items, 0)
+
+	err = app.storage.Put(cns.KvsDirNamePrefix+"test/value", bytes.NewBuffer([]byte("v")))
+	require.Nil(t, err)
+
+	items, _, err = app.core.Static.List("", &types.DirListParsSt{ListParams: dopTypes.ListParams{PageSize: 10}})
+	require.Nil(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "docs", items[0].Name)
+}
+
+func TestDedup(t *testing.T) {
+	stg := storageMock.New()
+
+	cr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{Dedup: true, Expiration: time.Hour}, true)
+
+	blobCount := func() int {
+		result := 0
+
+		dirs, err := stg.List(cns.DedupDirName + "/blobs")
+		if err == dopErrs.ObjectNotFound {
+			return 0
+		}
+		require.Nil(t, err)
+
+		for _, dir := range dirs {
+			blobs, err := stg.List(cns.DedupDirName + "/blobs/" + dir.Name)
+			require.Nil(t, err)
+			result += len(blobs)
+		}
+
+		return result
+	}
+
+	fPath1, err := cr.Static.Create("docs", "a.pdf", bytes.NewBuffer([]byte("pdf_data")), false, false, nil, nil)
+	require.Nil(t, err)
+
+	fPath2, err := cr.Static.Create("docs", "b.pdf", bytes.NewBuffer([]byte("pdf_data")), false, false, nil, nil)
+	require.Nil(t, err)
+	require.NotEqual(t, fPath1, fPath2)
+
+	fPath3, err := cr.Static.Create("docs", "c.pdf", bytes.NewBuffer([]byte("other_data")), false, false, nil, nil)
+	require.Nil(t, err)
+
+	require.Equal(t, 2, blobCount())
+
+	for _, fPath := range []string{fPath1, fPath2} {
+		file, err := cr.Static.Get(fPath, &types.ImgParsSt{}, false)
+		require.Nil(t, err)
+		require.Equal(t, int64(8), file.Size)
+		content, err := io.ReadAll(file.Content)
+		require.Nil(t, err)
+		require.Nil(t, file.Close())
+		require.Equal(t, "pdf_data", string(content))
+	}
+
+	_, err = cr.Static.Get(cns.DedupDirName+"/blobs", &types.ImgParsSt{}, false)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	err = cr.Static.Remove(fPath1)
+	require.Nil(t, err)
+	require.Equal(t, 2, blobCount())
+
+	_, err = cr.Static.Get(fPath1, &types.ImgParsSt{}, false)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	file, err := cr.Static.Get(fPath2, &types.ImgParsSt{}, false)
+	require.Nil(t, err)
+	require.Nil(t, file.Close())
+
+	err = cr.Static.Remove(fPath2)
+	require.Nil(t, err)
+	require.Equal(t, 1, blobCount())
+
+	err = cr.Static.Remove(fPath3)
+	require.Nil(t, err)
+	require.Equal(t, 0, blobCount())
+}
+
+func TestDedupOff(t *testing.T) {
+	stg := storageMock.New()
+
+	cr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{Dedup: true}, true)
+
+	fPath1, err := cr.Static.Create("docs", "a.pdf", bytes.NewBuffer([]byte("pdf_data")), false, false, nil, nil)
+	require.Nil(t, err)
+
+	fPath2, err := cr.Static.Create("docs", "b.pdf", bytes.NewBuffer([]byte("pdf_data")), false, false, nil, nil)
+	require.Nil(t, err)
+
+	// links created before turning dedup off are still served and ref-counted
+	cr = core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+	for _, fPath := range []string{fPath1, fPath2} {
+		file, err := cr.Static.Get(fPath, &types.ImgParsSt{}, false)
+		require.Nil(t, err)
+		require.Equal(t, int64(8), file.Size)
+		content, err := io.ReadAll(file.Content)
+		require.Nil(t, err)
+		require.Nil(t, file.Close())
+		require.Equal(t, "pdf_data", string(content))
+	}
+
+	blobDirs, err := stg.List(cns.DedupDirName + "/blobs")
+	require.Nil(t, err)
+	require.Len(t, blobDirs, 1)
+
+	blobs, err := stg.List(cns.DedupDirName + "/blobs/" + blobDirs[0].Name)
+	require.Nil(t, err)
+	require.Len(t, blobs, 1)
+
+	blobPath := cns.DedupDirName + "/blobs/" + blobDirs[0].Name + "/" + blobs[0].Name
+
+	err = cr.Static.Remove(fPath1)
+	require.Nil(t, err)
+
+	_, err = stg.Stat(blobPath)
+	require.Nil(t, err)
+
+	err = cr.Static.Remove(fPath2)
+	require.Nil(t, err)
+
+	_, err = stg.Stat(blobPath)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	_, err = cr.Static.Get(fPath2, &types.ImgParsSt{}, false)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+}
+
+func TestDedupLinkSpoof(t *testing.T) {
+	stg := storageMock.New()
+
+	for _, dedup := range []bool{false, true} {
+		cr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{Dedup: dedup}, true)
+
+		targetPath, err := cr.Static.Create("private", "a.txt", bytes.NewBufferString("secret"), false, false, nil, nil)
+		require.Nil(t, err)
+
+		if dedup {
+			targetPath, err = cr.Static.Create("private", "b.txt", bytes.NewBufferString("secret"), false, false, nil, nil)
+			require.Nil(t, err)
+		}
+
+		// hash climbing out of blobs dir to the target
+		hash := "../" + targetPath
+		hash = "../" + strings.Repeat("/", 64-len(hash)) + targetPath
+		require.Len(t, hash, 64)
+
+		blobs, err := stg.List(cns.DedupDirName + "/blobs")
+		if dedup {
+			require.Nil(t, err)
+			require.Len(t, blobs, 1)
+		}
+
+		for _, linkHash := range []string{hash, strings.Repeat("0", 64)} {
+			link := "kazan-blob:sha256:" + linkHash
+
+			linkPath, err := cr.Static.Create("docs", "link.txt", bytes.NewBufferString(link), false, false, nil, nil)
+			require.Nil(t, err)
+
+			file, err := cr.Static.Get(linkPath, &types.ImgParsSt{}, false)
+			require.Nil(t, err)
+			content, err := io.ReadAll(file.Content)
+			require.Nil(t, err)
+			require.Nil(t, file.Close())
+			require.Equal(t, link, string(content))
+
+			// written directly, without ref record
+			err = stg.Put(linkPath, bytes.NewBufferString(link))
+			require.Nil(t, err)
+
+			file, err = cr.Static.Get(linkPath, &types.ImgParsSt{}, false)
+			require.Nil(t, err)
+			content, err = io.ReadAll(file.Content)
+			require.Nil(t, err)
+			require.Nil(t, file.Close())
+			require.Equal(t, link, string(content))
+
+			err = cr.Static.Remove(linkPath)
+			require.Nil(t, err)
+		}
+
+		file, err := cr.Static.Get(targetPath, &types.ImgParsSt{}, false)
+		require.Nil(t, err)
+		content, err := io.ReadAll(file.Content)
+		require.Nil(t, err)
+		require.Nil(t, file.Close())
+		require.Equal(t, "secret", string(content))
+	}
+}
+
+func TestImgFormat(t *testing.T) {
+	cleanTestDir()
+
+	srcImg := imaging.New(100, 50, color.RGBA{R: 0xaa, G: 0x00, B: 0x00, A: 0xff})
+
+	srcImgBuffer := new(bytes.Buffer)
+
+	err := imaging.Encode(srcImgBuffer, srcImg, imaging.JPEG)
+	require.Nil(t, err)
+
+	fPath, err := app.core.Static.Create("photos", "a.jpg", srcImgBuffer, false, false, nil, nil)
+	require.Nil(t, err)
+
+	fName, _, fContent, err := getStatic(fPath, &types.ImgParsSt{Format: "webp"}, false)
+	require.Nil(t, err)
+	require.Equal(t, ".webp", path.Ext(fName))
+	require.Equal(t, "RIFF", string(fContent[:4]))
+	require.Equal(t, "WEBP", string(fContent[8:12]))
+
+	img, err := imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+	require.Equal(t, 100, img.Bounds().Dx())
+
+	fName, _, fContent, err = getStatic(fPath, &types.ImgParsSt{Width: 50, Format: "png"}, false)
+	require.Nil(t, err)
+	require.Equal(t, ".png", path.Ext(fName))
+
+	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+	require.Equal(t, 50, img.Bounds().Dx())
+
+	fName, _, _, err = getStatic(fPath, &types.ImgParsSt{Format: "jpeg"}, false)
+	require.Nil(t, err)
+	require.Equal(t, ".jpg", path.Ext(fName))
+
+	fName, _, fContent, err = getStatic(fPath, &types.ImgParsSt{Width: 50, Format: "avif"}, false)
+	require.Nil(t, err)
+	require.Equal(t, ".avif", path.Ext(fName))
+	require.Equal(t, "ftypavif", string(fContent[4:12]))
+
+	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+	require.Equal(t, 50, img.Bounds().Dx())
+
+	// avif is also accepted as a source
+	avifPath, err := app.core.Static.Create("photos", "b.avif", bytes.NewReader(fContent), false, false, nil, nil)
+	require.Nil(t, err)
+
+	_, _, fContent, err = getStatic(avifPath, &types.ImgParsSt{Width: 20, Format: "png"}, false)
+	require.Nil(t, err)
+
+	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+	require.Equal(t, 20, img.Bounds().Dx())
+
+	_, _, _, err = getStatic(fPath, &types.ImgParsSt{Format: "xxx"}, false)
+	require.Equal(t, errs.BadImgFormat, err)
+
+	require.Equal(t, "avif", app.core.Img.NegotiateFormat("image/avif,image/webp,image/apng,*/*;q=0.8"))
+	require.Equal(t, "webp", app.core.Img.NegotiateFormat("image/webp,image/apng,*/*;q=0.8"))
+	require.Equal(t, "", app.core.Img.NegotiateFormat("image/png,*/*;q=0.8"))
+	require.Equal(t, "", app.core.Img.NegotiateFormat("image/webp;q=0"))
+	require.Equal(t, "", app.core.Img.NegotiateFormat(""))
+}
+
+func TestImgGif(t *testing.T) {
+	cleanTestDir()
+
+	palette := color.Palette{color.RGBA{A: 0xff}, color.RGBA{R: 0xff, A: 0xff}, color.RGBA{G: 0xff, A: 0xff}, color.RGBA{B: 0xff, A: 0xff}}
+
+	anim := &gif.GIF{
+		Config: image.Config{ColorModel: palette, Width: 100, Height: 50},
+	}
+
+	for i, rect := range []image.Rectangle{
+		image.Rect(0, 0, 100, 50),
+		image.Rect(0, 0, 50, 50),
+		image.Rect(50, 0, 100, 50),
+	} {
+		frame := image.NewPaletted(rect, palette)
+		draw.Draw(frame, rect, image.NewUniform(palette[i+1]), image.Point{}, draw.Src)
+
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, (i+1)*10)
+		anim.Disposal = append(anim.Disposal, gif.DisposalNone)
+	}
+
+	anim.Disposal[1] = gif.DisposalPrevious
+
+	animBuffer := new(bytes.Buffer)
+
+	err := gif.EncodeAll(animBuffer, anim)
+	require.Nil(t, err)
+
+	fPath, err := app.core.Static.Create("stickers", "a.gif", animBuffer, false, false, nil, nil)
+	require.Nil(t, err)
+
+	fName, _, fContent, err := getStatic(fPath, &types.ImgParsSt{Width: 50}, false)
+	require.Nil(t, err)
+	require.Equal(t, ".gif", path.Ext(fName))
+
+	resAnim, err := gif.DecodeAll(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+	require.Len(t, resAnim.Image, 3)
+	require.Equal(t, []int{10, 20, 30}, resAnim.Delay)
+	require.Equal(t, anim.Disposal, resAnim.Disposal)
+	require.Equal(t, 50, resAnim.Config.Width)
+	require.Equal(t, 25, resAnim.Config.Height)
+
+	for _, frame := range resAnim.Image {
+		require.Equal(t, image.Rect(0, 0, 50, 25), frame.Bounds())
+	}
+
+	// second frame is disposed to previous, so third one is drawn over the first
+	fName, _, fContent, err = getStatic(fPath, &types.ImgParsSt{Format: "png", Frame: dopTools.NewPtr(2)}, false)
+	require.Nil(t, err)
+	require.Equal(t, ".png", path.Ext(fName))
+
+	img, err := imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+	require.Equal(t, 100, img.Bounds().Dx())
+
+	r, g, b, _ := img.At(10, 10).RGBA()
+	require.Equal(t, []uint32{0xffff, 0, 0}, []uint32{r, g, b})
+
+	r, g, b, _ = img.At(90, 10).RGBA()
+	require.Equal(t, []uint32{0, 0, 0xffff}, []uint32{r, g, b})
+
+	_, _, _, err = getStatic(fPath, &types.ImgParsSt{Frame: dopTools.NewPtr(3)}, false)
+	require.Equal(t, errs.BadImgFrame, err)
+}
+
+func TestImgQuality(t *testing.T) {
+	cleanTestDir()
+
+	srcImg := imaging.New(203, 151, color.White)
+	for y := 0; y < 151; y++ {
+		for x := 0; x < 203; x++ {
+			srcImg.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8((x + y) / 2), A: 0xff})
+		}
+	}
+
+	srcImgBuffer := new(bytes.Buffer)
+
+	err := imaging.Encode(srcImgBuffer, srcImg, imaging.PNG)
+	require.Nil(t, err)
+
+	fPath, err := app.core.Static.Create("photos", "a.png", srcImgBuffer, false, false, nil, nil)
+	require.Nil(t, err)
+
+	_, _, lowContent, err := getStatic(fPath, &types.ImgParsSt{Format: "jpeg", Quality: 20}, false)
+	require.Nil(t, err)
+
+	_, _, highContent, err := getStatic(fPath, &types.ImgParsSt{Format: "jpeg", Quality: 95}, false)
+	require.Nil(t, err)
+	require.Less(t, len(lowContent), len(highContent))
+
+	img, err := jpeg.Decode(bytes.NewBuffer(lowContent))
+	require.Nil(t, err)
+	require.Equal(t, image.Rect(0, 0, 203, 151), img.Bounds())
+
+	_, _, noContent, err := getStatic(fPath, &types.ImgParsSt{Width: 200, PngCompression: "no"}, false)
+	require.Nil(t, err)
+
+	_, _, bestContent, err := getStatic(fPath, &types.ImgParsSt{Width: 200, PngCompression: "best"}, false)
+	require.Nil(t, err)
+	require.Less(t, len(bestContent), len(noContent))
+
+	_, _, _, err = getStatic(fPath, &types.ImgParsSt{Width: 200, PngCompression: "xxx"}, false)
+	require.Equal(t, errs.BadImgParams, err)
+
+	// server-side options are applied to the fit on upload too
+	uploadSize := func(quality int) int {
+		cr := core.New(app.lg, storageMock.New(), 100, 100, core.ImgOptionsSt{Quality: quality}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+		srcImgBuffer.Reset()
+
+		err = imaging.Encode(srcImgBuffer, srcImg, imaging.JPEG)
+		require.Nil(t, err)
+
+		fPath, err := cr.Static.Create("photos", "a.jpg", srcImgBuffer, false, false, nil, nil)
+		require.Nil(t, err)
+
+		file, err := cr.Static.Get(fPath, &types.ImgParsSt{}, false)
+		require.Nil(t, err)
+		defer file.Close()
+
+		fContent, err := io.ReadAll(file.Content)
+		require.Nil(t, err)
+
+		img, err := jpeg.Decode(bytes.NewBuffer(fContent))
+		require.Nil(t, err)
+		require.Equal(t, 100, img.Bounds().Dx())
+
+		return len(fContent)
+	}
+
+	require.Less(t, uploadSize(20), uploadSize(95))
+}
+
+func TestImgPreset(t *testing.T) {
+	stg := storageMock.New()
+
+	imgOpts := core.ImgOptionsSt{
+		Presets: map[string]*types.ImgParsSt{
+			"avatar_small": {Method: "fill", Width: 20, Height: 20, Format: "png"},
+			"avatar_auto":  {Method: "fill", Width: 20, Height: 20, Format: "auto"},
+		},
+	}
+
+	cr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, imgOpts, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+	err := cr.Img.ValidatePresets()
+	require.Nil(t, err)
+
+	srcImgBuffer := new(bytes.Buffer)
+
+	err = imaging.Encode(srcImgBuffer, imaging.New(100, 50, color.White), imaging.JPEG)
+	require.Nil(t, err)
+
+	fPath, err := cr.Static.Create("photos", "a.jpg", srcImgBuffer, false, false, nil, nil)
+	require.Nil(t, err)
+
+	imgPars, err := cr.Img.ResolvePars("avatar_small", &types.ImgParsSt{})
+	require.Nil(t, err)
+	require.Equal(t, *imgOpts.Presets["avatar_small"], *imgPars)
+
+	file, err := cr.Static.Get(fPath, imgPars, false)
+	require.Nil(t, err)
+	defer file.Close()
+
+	require.Equal(t, ".png", path.Ext(file.Name))
+
+	img, err := imaging.Decode(file.Content)
+	require.Nil(t, err)
+	require.Equal(t, image.Rect(0, 0, 20, 20), img.Bounds())
+
+	// preset is not changed by request handling
+	require.Equal(t, 20, imgOpts.Presets["avatar_small"].Width)
+
+	_, err = cr.Img.ResolvePars("unknown", &types.ImgParsSt{})
+	require.Equal(t, errs.BadImgPreset, err)
+
+	imgPars, err = cr.Img.ResolvePars("avatar_auto", &types.ImgParsSt{})
+	require.Nil(t, err)
+	require.Equal(t, "auto", imgPars.Format)
+
+	_, err = cr.Img.ResolvePars("avatar_small", &types.ImgParsSt{Width: 10})
+	require.Equal(t, errs.BadImgParams, err)
+
+	imgPars, err = cr.Img.ResolvePars("", &types.ImgParsSt{Width: 10})
+	require.Nil(t, err)
+	require.Equal(t, 10, imgPars.Width)
+
+	imgOpts.Strict = true
+	imgOpts.Presets["bad"] = &types.ImgParsSt{Format: "xxx"}
+
+	cr = core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, imgOpts, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+	err = cr.Img.ValidatePresets()
+	require.ErrorIs(t, err, errs.BadImgFormat)
+
+	_, err = cr.Img.ResolvePars("", &types.ImgParsSt{Width: 10})
+	require.Equal(t, errs.ImgParamsNotAllowed, err)
+
+	imgPars, err = cr.Img.ResolvePars("", &types.ImgParsSt{})
+	require.Nil(t, err)
+	require.True(t, imgPars.IsEmpty())
+
+	_, err = cr.Img.ResolvePars("avatar_small", &types.ImgParsSt{})
+	require.Nil(t, err)
+}
+
+func TestImgCrop(t *testing.T) {
+	cleanTestDir()
+
+	// left half is plain, right half is detailed
+	srcImg := imaging.New(200, 100, color.White)
+	for y := 0; y < 100; y++ {
+		for x := 100; x < 200; x++ {
+			srcImg.Set(x, y, color.Gray{Y: uint8((x*37 + y*91) % 256)})
+		}
+	}
+
+	srcImgBuffer := new(bytes.Buffer)
+
+	err := imaging.Encode(srcImgBuffer, srcImg, imaging.PNG)
+	require.Nil(t, err)
+
+	fPath, err := app.core.Static.Create("photos", "a.png", srcImgBuffer, false, false, nil, nil)
+	require.Nil(t, err)
+
+	isPlain := func(imgPars *types.ImgParsSt) bool {
+		_, _, fContent, err := getStatic(fPath, imgPars, false)
+		require.Nil(t, err)
+
+		img, err := imaging.Decode(bytes.NewBuffer(fContent))
+		require.Nil(t, err)
+		require.Equal(t, image.Rect(0, 0, 50, 50), img.Bounds())
+
+		r, _, _, _ := img.At(25, 25).RGBA()
+
+		return r == 0xffff
+	}
+
+	require.True(t, isPlain(&types.ImgParsSt{Width: 50, Height: 50, Gravity: "left"}))
+	require.False(t, isPlain(&types.ImgParsSt{Width: 50, Height: 50, Gravity: "right"}))
+	require.False(t, isPlain(&types.ImgParsSt{Width: 50, Height: 50, Gravity: "smart"}))
+	require.True(t, isPlain(&types.ImgParsSt{Method: "crop", CropX: 10, CropY: 10, CropW: 80, CropH: 80, Width: 50}))
+
+	_, _, fContent, err := getStatic(fPath, &types.ImgParsSt{Method: "crop", CropX: 150, CropY: 20, CropW: 100, CropH: 40}, false)
+	require.Nil(t, err)
+
+	img, err := imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+	require.Equal(t, image.Rect(0, 0, 50, 40), img.Bounds())
+
+	_, _, _, err = getStatic(fPath, &types.ImgParsSt{Method: "crop", CropX: 300, CropW: 10, CropH: 10}, false)
+	require.Equal(t, errs.BadImgParams, err)
+
+	_, _, _, err = getStatic(fPath, &types.ImgParsSt{Method: "crop", Width: 10}, false)
+	require.Equal(t, errs.BadImgParams, err)
+
+	_, _, _, err = getStatic(fPath, &types.ImgParsSt{Width: 10, Gravity: "xxx"}, false)
+	require.Equal(t, errs.BadImgParams, err)
+}
+
+func TestImgAdjust(t *testing.T) {
+	cleanTestDir()
+
+	// red left half, blue right half
+	srcImg := imaging.New(200, 100, color.NRGBA{R: 255, A: 255})
+	for y := 0; y < 100; y++ {
+		for x := 100; x < 200; x++ {
+			srcImg.Set(x, y, color.NRGBA{B: 255, A: 255})
+		}
+	}
+
+	srcImgBuffer := new(bytes.Buffer)
+
+	err := imaging.Encode(srcImgBuffer, srcImg, imaging.PNG)
+	require.Nil(t, err)
+
+	fPath, err := app.core.Static.Create("photos", "a.png", srcImgBuffer, false, false, nil, nil)
+	require.Nil(t, err)
+
+	get := func(imgPars *types.ImgParsSt) image.Image {
+		_, _, fContent, err := getStatic(fPath, imgPars, false)
+		require.Nil(t, err)
+
+		img, err := imaging.Decode(bytes.NewBuffer(fContent))
+		require.Nil(t, err)
+
+		return img
+	}
+
+	rgb := func(img image.Image, x, y int) [3]uint8 {
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		return [3]uint8{c.R, c.G, c.B}
+	}
+
+	// clockwise: red goes to the top
+	img := get(&types.ImgParsSt{Rotate: 90})
+	require.Equal(t, image.Rect(0, 0, 100, 200), img.Bounds())
+	require.Equal(t, [3]uint8{255, 0, 0}, rgb(img, 50, 10))
+	require.Equal(t, [3]uint8{0, 0, 255}, rgb(img, 50, 190))
+
+	img = get(&types.ImgParsSt{Rotate: 45, Background: "00ff00"})
+	require.Equal(t, [3]uint8{0, 255, 0}, rgb(img, 0, 0))
+
+	img = get(&types.ImgParsSt{FlipH: true})
+	require.Equal(t, [3]uint8{0, 0, 255}, rgb(img, 10, 50))
+
+	img = get(&types.ImgParsSt{FlipV: true})
+	require.Equal(t, [3]uint8{255, 0, 0}, rgb(img, 10, 50))
+
+	img = get(&types.ImgParsSt{Invert: true})
+	require.Equal(t, [3]uint8{0, 255, 255}, rgb(img, 10, 50))
+
+	img = get(&types.ImgParsSt{Saturation: -100})
+	c := rgb(img, 10, 50)
+	require.Equal(t, c[0], c[1])
+	require.Equal(t, c[0], c[2])
+
+	img = get(&types.ImgParsSt{Brightness: 50, Contrast: 10, Gamma: 1.5, Sharpen: 1})
+	require.Equal(t, image.Rect(0, 0, 200, 100), img.Bounds())
+
+	for _, pars := range []*types.ImgParsSt{
+		{Brightness: 101},
+		{Contrast: -101},
+		{Saturation: 200},
+		{Gamma: -1},
+		{Sharpen: -1},
+		{Rotate: 10, Background: "zz0000"},
+		{Rotate: 10, Background: "ff00"},
+		{Rotate: math.NaN()},
+		{Rotate: math.Inf(1)},
+		{Sharpen: math.Inf(1)},
+		{Sharpen: cns.ImgMaxSigma + 1},
+		{Blur: math.NaN()},
+		{Blur: cns.ImgMaxSigma + 1},
+		{Brightness: math.NaN()},
+		{Contrast: math.NaN()},
+		{Gamma: math.Inf(1)},
+		{Saturation: math.NaN()},
+		{Width: 10, Dpr: math.NaN()},
+		{Width: -10},
+		{Height: -1},
+		{Width: 10, Quality: -1},
+		{Width: 10, Quality: 101},
+		{Width: 10, Method: "xxx"},
+		{Blur: -1},
+	} {
+		_, _, _, err = getStatic(fPath, pars, false)
+		require.Equal(t, errs.BadImgParams, err)
+	}
+}
+
+func TestImgWMark(t *testing.T) {
+	cleanTestDir()
+
+	cr := core.New(app.lg, app.storage, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{
+		WMark:         imaging.New(20, 20, color.NRGBA{R: 255, A: 255}),
+		WMarkPosition: "bottom_right",
+		WMarkOpacity:  1,
+		WMarkScale:    0.1,
+		WMarkMargin:   5,
+		WMarkDirPaths: []string{"photos"},
+	}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+	require.Nil(t, cr.Img.ValidateWMark())
+
+	get := func(reqPath string, imgPars *types.ImgParsSt) ([]byte, error) {
+		file, err := cr.Static.Get(reqPath, imgPars, false)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		return io.ReadAll(file.Content)
+	}
+
+	red := func(img image.Image, x, y int) bool {
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		return c.R == 255 && c.G == 0 && c.B == 0
+	}
+
+	srcImgBuffer := new(bytes.Buffer)
+
+	err := imaging.Encode(srcImgBuffer, imaging.New(200, 100, color.White), imaging.PNG)
+	require.Nil(t, err)
+
+	srcImgBytes := srcImgBuffer.Bytes()
+
+	fPath, err := cr.Static.Create("photos", "a.png", bytes.NewReader(srcImgBytes), false, false, nil, nil)
+	require.Nil(t, err)
+
+	for _, reqPath := range []string{fPath, "docs/../" + fPath} {
+		fContent, err := get(reqPath, &types.ImgParsSt{})
+		require.Nil(t, err)
+		require.NotEqual(t, srcImgBytes, fContent)
+
+		img, err := imaging.Decode(bytes.NewBuffer(fContent))
+		require.Nil(t, err)
+		require.Equal(t, image.Rect(0, 0, 200, 100), img.Bounds())
+		require.True(t, red(img, 190, 90))
+		require.False(t, red(img, 170, 90))
+		require.False(t, red(img, 10, 10))
+	}
+
+	// watermark is scaled to the output
+	fContent, err := get(fPath, &types.ImgParsSt{Width: 100})
+	require.Nil(t, err)
+
+	img, err := imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+	require.Equal(t, image.Rect(0, 0, 100, 50), img.Bounds())
+	require.True(t, red(img, 90, 40))
+	require.False(t, red(img, 80, 40))
+
+	// broken image is not served as is
+	fPath, err = cr.Static.Create("photos", "b.png", bytes.NewBufferString("not an image"), true, false, nil, nil)
+	require.Nil(t, err)
+
+	_, err = get(fPath, &types.ImgParsSt{})
+	require.NotNil(t, err)
+
+	// archive of zip-directory is not given, it would contain originals
+	zipBuffer, err := createZipArchive([]fsItemSt{{p: "a.png", c: string(srcImgBytes)}})
+	require.Nil(t, err)
+
+	zipPath, err := cr.Static.Create("photos", "a.zip", zipBuffer, false, true, nil, nil)
+	require.Nil(t, err)
+
+	_, err = cr.Static.Get(zipPath, &types.ImgParsSt{}, true)
+	require.Equal(t, dopErrs.PermissionDenied, err)
+
+	// placeholder would be made of the original
+	_, err = cr.Static.Placeholder(zipPath + "a.png")
+	require.Equal(t, dopErrs.PermissionDenied, err)
+
+	fContent, err = get(zipPath+"a.png", &types.ImgParsSt{})
+	require.Nil(t, err)
+	require.NotEqual(t, srcImgBytes, fContent)
+
+	// other directories are not affected
+	fPath, err = cr.Static.Create("photos2", "a.png", bytes.NewReader(srcImgBytes), false, false, nil, nil)
+	require.Nil(t, err)
+
+	fContent, err = get(fPath, &types.ImgParsSt{})
+	require.Nil(t, err)
+	require.Equal(t, srcImgBytes, fContent)
+
+	_, err = cr.Static.Placeholder(fPath)
+	require.Nil(t, err)
+
+	_, err = get(fPath, &types.ImgParsSt{WMark: true})
+	require.Equal(t, errs.ImgParamsNotAllowed, err)
+
+	cr = core.New(app.lg, app.storage, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{
+		WMark:         imaging.New(20, 20, color.NRGBA{R: 255, A: 255}),
+		WMarkPosition: "top_left",
+		WMarkOpacity:  1,
+		WMarkOnDemand: true,
+	}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+	fContent, err = get(fPath, &types.ImgParsSt{WMark: true})
+	require.Nil(t, err)
+
+	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	require.Nil(t, err)
+	require.True(t, red(img, 0, 0))
+	require.True(t, red(img, 19, 19))
+	require.False(t, red(img, 20, 20))
+
+	cr = core.New(app.lg, app.storage, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{
+		WMarkDirPaths: []string{"photos"},
+	}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+	require.NotNil(t, cr.Img.ValidateWMark())
+}
+
+func TestImgInfo(t *testing.T) {
+	cleanTestDir()
+
+	// red 30x20 on the left, blue 10x20 on the right
+	srcImg := imaging.New(40, 20, color.NRGBA{R: 255, A: 255})
+	for y := 0; y < 20; y++ {
+		for x := 30; x < 40; x++ {
+			srcImg.Set(x, y, color.NRGBA{B: 255, A: 255})
+		}
+	}
+
+	jpegContent := jpegWithExif(t, srcImg, 6)
+
+	fPath, err := app.core.Static.Create("photos", "a.jpg", bytes.NewReader(jpegContent), true, false, nil, nil)
+	require.Nil(t, err)
+
+	info, err := app.core.Static.Info(fPath)
+	require.Nil(t, err)
+	require.Equal(t, 20, info.Width) // rotated by orientation
+	require.Equal(t, 40, info.Height)
+	require.Equal(t, "jpeg", info.Format)
+	require.Equal(t, "ycbcr", info.ColorModel)
+	require.Equal(t, int64(len(jpegContent)), info.Size)
+	require.Equal(t, 1, info.Frames)
+	require.Len(t, info.DominantColor, 6)
+	require.Equal(t, "f", info.DominantColor[:1])
+	require.NotNil(t, info.Exif)
+	require.Equal(t, "TestMake", info.Exif.Make)
+	require.Equal(t, "TestModel", info.Exif.Model)
+	require.NotNil(t, info.Exif.CapturedAt)
+	require.Equal(t, "2020-01-02 03:04:05", info.Exif.CapturedAt.Format("2006-01-02 15:04:05"))
+	require.NotNil(t, info.Exif.Lat)
+	require.NotNil(t, info.Exif.Lon)
+	require.InDelta(t, 43.25, *info.Exif.Lat, 0.0001)
+	require.InDelta(t, 76.9, *info.Exif.Lon, 0.0001)
+
+	srcImgBuffer := new(bytes.Buffer)
+
+	err = imaging.Encode(srcImgBuffer, srcImg, imaging.PNG)
+	require.Nil(t, err)
+
+	fPath, err = app.core.Static.Create("photos", "a.png", srcImgBuffer, true, false, nil, nil)
+	require.Nil(t, err)
+
+	info, err = app.core.Static.Info(fPath)
+	require.Nil(t, err)
+	require.Equal(t, 40, info.Width)
+	require.Equal(t, 20, info.Height)
+	require.Equal(t, "png", info.Format)
+	require.Equal(t, "ff0000", info.DominantColor)
+	require.Nil(t, info.Exif)
+
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.White, color.Black}))
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	srcImgBuffer = new(bytes.Buffer)
+
+	err = gif.EncodeAll(srcImgBuffer, anim)
+	require.Nil(t, err)
+
+	fPath, err = app.core.Static.Create("photos", "a.gif", srcImgBuffer, true, false, nil, nil)
+	require.Nil(t, err)
+
+	info, err = app.core.Static.Info(fPath)
+	require.Nil(t, err)
+	require.Equal(t, "gif", info.Format)
+	require.Equal(t, "paletted", info.ColorModel)
+	require.Equal(t, 3, info.Frames)
+	require.Equal(t, "ffffff", info.DominantColor)
+
+	fPath, err = app.core.Static.Create("docs", "a.txt", bytes.NewBufferString("text"), true, false, nil, nil)
+	require.Nil(t, err)
+
+	_, err = app.core.Static.Info(fPath)
+	require.Equal(t, errs.NotImg, err)
+
+	_, err = app.core.Static.Info("photos/not-exists.jpg")
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+}
+
+func TestStripMeta(t *testing.T) {
+	cleanTestDir()
+
+	cr := core.New(app.lg, app.storage, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{StripMeta: true}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+	get := func(fPath string) []byte {
+		file, err := cr.Static.Get(fPath, &types.ImgParsSt{}, false)
+		require.Nil(t, err)
+		defer file.Close()
+
+		fContent, err := io.ReadAll(file.Content)
+		require.Nil(t, err)
+
+		return fContent
+	}
+
+	srcImg := imaging.New(40, 20, color.NRGBA{R: 255, A: 255})
+
+	// metadata is cut out, pixels are untouched
+	jpegContent := jpegWithExif(t, srcImg, 1)
+
+	fPath, err := cr.Static.Create("photos", "a.jpg", bytes.NewReader(jpegContent), true, false, nil, nil)
+	require.Nil(t, err)
+
+	fContent := get(fPath)
+	require.False(t, bytes.Contains(fContent, []byte("Exif")))
+	require.False(t, bytes.Contains(fContent, []byte("TestMake")))
+	require.True(t, bytes.HasSuffix(jpegContent, fContent[2:]))
+
+	info, err := cr.Static.Info(fPath)
+	require.Nil(t, err)
+	require.Nil(t, info.Exif)
+	require.Equal(t, 40, info.Width)
+
+	meta, err := cr.Static.GetMeta(fPath)
+	require.Nil(t, err)
+	require.Equal(t, int64(len(fContent)), meta.Size)
+
+	// orientation is applied to pixels
+	fPath, err = cr.Static.Create("photos", "a.jpg", bytes.NewReader(jpegWithExif(t, srcImg, 6)), true, false, nil, nil)
+	require.Nil(t, err)
+
+	fContent = get(fPath)
+	require.False(t, bytes.Contains(fContent, []byte("TestMake")))
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(fContent))
+	require.Nil(t, err)
+	require.Equal(t, 20, cfg.Width)
+	require.Equal(t, 40, cfg.Height)
+
+	// per upload option overrides the default
+	fPath, err = cr.Static.Create("photos", "a.jpg", bytes.NewReader(jpegContent), true, false, &[]bool{false}[0], nil)
+	require.Nil(t, err)
+	require.Equal(t, jpegContent, get(fPath))
+
+	fPath, err = app.core.Static.Create("photos", "a.jpg", bytes.NewReader(jpegContent), true, false, &[]bool{true}[0], nil)
+	require.Nil(t, err)
+	require.False(t, bytes.Contains(get(fPath), []byte("TestMake")))
+
+	// png text chunks
+	pngBuffer := new(bytes.Buffer)
+
+	err = imaging.Encode(pngBuffer, srcImg, imaging.PNG)
+	require.Nil(t, err)
+
+	pngContent := pngBuffer.Bytes()
+
+	textChunk := binary.BigEndian.AppendUint32(nil, uint32(len("Comment\x00secret")))
+	textChunk = append(textChunk, "tEXtComment\x00secret"...)
+	textChunk = binary.BigEndian.AppendUint32(textChunk, crc32.ChecksumIEEE(textChunk[4:]))
+
+	ihdrEnd := 8 + 12 + 13
+	pngContent = append(append(append([]byte{}, pngContent[:ihdrEnd]...), textChunk...), pngContent[ihdrEnd:]...)
+
+	fPath, err = cr.Static.Create("photos", "a.png", bytes.NewReader(pngContent), true, false, nil, nil)
+	require.Nil(t, err)
+
+	fContent = get(fPath)
+	require.False(t, bytes.Contains(fContent, []byte("secret")))
+	require.Equal(t, pngBuffer.Bytes(), fContent)
+
+	// nothing to strip
+	fPath, err = cr.Static.Create("photos", "a.png", bytes.NewReader(pngBuffer.Bytes()), true, false, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, pngBuffer.Bytes(), get(fPath))
+
+	// format is taken from content, extension does not matter
+	fPath, err = cr.Static.Create("photos", "c.jpg", bytes.NewReader(pngContent), true, false, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, pngBuffer.Bytes(), get(fPath))
+
+	fPath, err = cr.Static.Create("photos", "c.png", bytes.NewReader(jpegContent), true, false, nil, nil)
+	require.Nil(t, err)
+	require.False(t, bytes.Contains(get(fPath), []byte("TestMake")))
+
+	_, err = cr.Static.Create("photos", "b.jpg", bytes.NewBufferString("not an image"), true, false, nil, nil)
+	require.Equal(t, errs.BadFile, err)
+}
+
+func TestImgPlaceholder(t *testing.T) {
+	cleanTestDir()
+
+	srcImgBuffer := new(bytes.Buffer)
+
+	err := imaging.Encode(srcImgBuffer, imaging.New(200, 100, color.NRGBA{R: 255, A: 255}), imaging.PNG)
+	require.Nil(t, err)
+
+	fPath, err := app.core.Static.Create("photos", "a.png", srcImgBuffer, false, false, nil, nil)
+	require.Nil(t, err)
+
+	placeholder, err := app.core.Static.Placeholder(fPath)
+	require.Nil(t, err)
+	require.Len(t, placeholder.BlurHash, 28)            // 4x3 components
+	require.Equal(t, "L", placeholder.BlurHash[:1])     // size flag
+	require.Equal(t, "TI:j", placeholder.BlurHash[2:6]) // average color ff0000
+	require.True(t, strings.HasPrefix(placeholder.Lqip, "data:image/jpeg;base64,"))
+
+	lqipContent, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(placeholder.Lqip, "data:image/jpeg;base64,"))
+	require.Nil(t, err)
+
+	img, err := imaging.Decode(bytes.NewReader(lqipContent))
+	require.Nil(t, err)
+	require.Equal(t, image.Rect(0, 0, 16, 8), img.Bounds())
+
+	// kept in metadata
+	meta, err := app.core.Static.GetMeta(fPath)
+	require.Nil(t, err)
+	require.Equal(t, placeholder.BlurHash, meta.BlurHash)
+	require.Equal(t, placeholder.Lqip, meta.Lqip)
+
+	placeholder2, err := app.core.Static.Placeholder(fPath)
+	require.Nil(t, err)
+	require.Equal(t, placeholder, placeholder2)
+
+	// transparent image
+	srcImgBuffer = new(bytes.Buffer)
+
+	err = imaging.Encode(srcImgBuffer, imaging.New(20, 20, color.NRGBA{}), imaging.PNG)
+	require.Nil(t, err)
+
+	fPath, err = app.core.Static.Create("photos", "b.png", srcImgBuffer, false, false, nil, nil)
+	require.Nil(t, err)
+
+	placeholder, err = app.core.Static.Placeholder(fPath)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(placeholder.Lqip, "data:image/png;base64,"))
+
+	fPath, err = app.core.Static.Create("docs", "a.txt", bytes.NewBufferString("text"), false, false, nil, nil)
+	require.Nil(t, err)
+
+	_, err = app.core.Static.Placeholder(fPath)
+	require.Equal(t, errs.NotImg, err)
+}
+
+func TestImgSrcset(t *testing.T) {
+	stg := storageMock.New()
+
+	imgOpts := core.ImgOptionsSt{
+		Presets: map[string]*types.ImgParsSt{
+			"thumb": {Method: "fill", Width: 100, Height: 50},
+			"card":  {Width: 300},
+			"tall":  {Height: 400},
+			"gray":  {Grayscale: true},
+		},
+	}
+
+	cr := core.New(app.lg, stg, 500, 500, imgOpts, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+	srcImgBuffer := new(bytes.Buffer)
+
+	err := imaging.Encode(srcImgBuffer, imaging.New(800, 400, color.White), imaging.PNG)
+	require.Nil(t, err)
+
+	fPath, err := cr.Static.Create("photos", "a.png", srcImgBuffer, true, false, nil, nil)
+	require.Nil(t, err)
+
+	getSize := func(imgPars *types.ImgParsSt) image.Point {
+		file, err := cr.Static.Get(fPath, imgPars, false)
+		require.Nil(t, err)
+		defer file.Close()
+
+		img, err := imaging.Decode(file.Content)
+		require.Nil(t, err)
+
+		return img.Bounds().Size()
+	}
+
+	// dpr multiplies sizes, but not over server maximum
+	require.Equal(t, image.Pt(200, 100), getSize(&types.ImgParsSt{Method: "fill", Width: 100, Height: 50, Dpr: 2}))
+	require.Equal(t, image.Pt(500, 250), getSize(&types.ImgParsSt{Method: "fill", Width: 300, Height: 150, Dpr: 2}))
+
+	imgPars, err := cr.Img.ResolvePars("thumb", &types.ImgParsSt{Dpr: 3})
+	require.Nil(t, err)
+	require.Equal(t, image.Pt(300, 150), getSize(imgPars))
+
+	// dpr does not scale over the original, aspect ratio is kept
+	smallImgBuffer := new(bytes.Buffer)
+
+	err = imaging.Encode(smallImgBuffer, imaging.New(150, 100, color.White), imaging.PNG)
+	require.Nil(t, err)
+
+	smallPath, err := cr.Static.Create("photos", "b.png", smallImgBuffer, true, false, nil, nil)
+	require.Nil(t, err)
+
+	file, err := cr.Static.Get(smallPath, &types.ImgParsSt{Method: "fill", Width: 100, Height: 30, Dpr: 3}, false)
+	require.Nil(t, err)
+
+	img, err := imaging.Decode(file.Content)
+	require.Nil(t, err)
+	require.Equal(t, image.Pt(150, 45), img.Bounds().Size())
+
+	file.Close()
+
+	// cached derivative is served without reading the source for its size
+	gStg := &gatedStorage{St: storageMock.New()}
+
+	cachedCr := core.New(app.lg, gStg, 500, 500, imgOpts, core.CacheOptionsSt{ImgSize: 1 << 20}, zipOpts, core.UploadOptionsSt{}, true)
+
+	smallImgBuffer.Reset()
+
+	err = imaging.Encode(smallImgBuffer, imaging.New(150, 100, color.White), imaging.PNG)
+	require.Nil(t, err)
+
+	smallPath, err = cachedCr.Static.Create("photos", "b.png", smallImgBuffer, true, false, nil, nil)
+	require.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		file, err = cachedCr.Static.Get(smallPath, &types.ImgParsSt{Method: "fill", Width: 100, Height: 30, Dpr: 3}, false)
+		require.Nil(t, err)
+
+		img, err = imaging.Decode(file.Content)
+		require.Nil(t, err)
+		require.Equal(t, image.Pt(150, 45), img.Bounds().Size())
+
+		file.Close()
+
+		gStg.panicPath = smallPath
+	}
+
+	_, err = cr.Static.Get(fPath, &types.ImgParsSt{Width: 100, Dpr: 10}, false)
+	require.Equal(t, errs.BadImgParams, err)
+
+	_, err = cr.Img.ResolvePars("thumb", &types.ImgParsSt{Width: 10, Dpr: 2})
+	require.Equal(t, errs.BadImgParams, err)
+
+	srcsetDprs := func(preset string) []float64 {
+		items, err := cr.Static.Srcset(fPath, preset, nil)
+		require.Nil(t, err)
+
+		result := make([]float64, 0, len(items))
+		for _, item := range items {
+			result = append(result, item.Dpr)
+		}
+
+		return result
+	}
+
+	require.Equal(t, []float64{1, 2, 3}, srcsetDprs("thumb"))
+	require.Equal(t, []float64{1}, srcsetDprs("card")) // 600 is over server maximum
+	require.Equal(t, []float64{1}, srcsetDprs("tall")) // 800 is over original
+	require.Equal(t, []float64{1}, srcsetDprs("gray"))
+
+	items, err := cr.Static.Srcset(fPath, "", []int{640, 320, 1024, 480})
+	require.Nil(t, err)
+	require.Equal(t, []*types.SrcsetItemSt{{Width: 320}, {Width: 480}, {Width: 500}}, items)
+
+	_, err = cr.Static.Srcset(fPath, "", nil)
+	require.Equal(t, errs.BadImgParams, err)
 
-		return os.Remove(p)
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
+	_, err = cr.Static.Srcset(fPath, "thumb", []int{100})
+	require.Equal(t, errs.BadImgParams, err)
+
+	_, err = cr.Static.Srcset(fPath, "xxx", nil)
+	require.Equal(t, errs.BadImgPreset, err)
+
+	_, err = cr.Static.Srcset(fPath, "", []int{0})
+	require.Equal(t, errs.BadImgParams, err)
 }
 
-func TestMain(m *testing.M) {
-	var err error
+func TestCache(t *testing.T) {
+	stg := storageMock.New()
 
-	err = os.MkdirAll(testDirPath, os.ModePerm)
-	if err != nil {
-		log.Fatal(err)
+	cr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{}, core.CacheOptionsSt{
+		RawSize:       1000,
+		ImgSize:       1 << 20,
+		MaxObjectSize: 600,
+	}, zipOpts, core.UploadOptionsSt{}, true)
+
+	create := func(fName string, size int) string {
+		fPath, err := cr.Static.Create("docs", fName, bytes.NewBufferString(strings.Repeat("x", size)), false, false, nil, nil)
+		require.Nil(t, err)
+		return fPath
 	}
 
-	viper.SetConfigFile(confPath)
-	_ = viper.ReadInConfig()
+	get := func(fPath string, imgPars *types.ImgParsSt) *types.StaticFileSt {
+		file, err := cr.Static.Get(fPath, imgPars, false)
+		require.Nil(t, err)
+		defer file.Close()
 
-	viper.AutomaticEnv()
+		_, err = io.ReadAll(file.Content)
+		require.Nil(t, err)
 
-	app.lg, err = zap.New(
-		"info",
-		true,
-		false,
-	)
-	if err != nil {
-		log.Fatal(err)
+		return file
 	}
-	defer app.lg.Sync()
 
-	app.cleaner = cleanerMock.New()
+	aPath := create("a.txt", 500)
+	bPath := create("b.txt", 500)
+	cPath := create("c.txt", 700)
+	dPath := create("d.txt", 300)
 
-	app.core = core.New(
-		app.lg,
-		app.cleaner,
-		testDirPath,
-		imgMaxWidth,
-		imgMaxHeight,
-		"",
-		0,
-		[]string{},
-		0,
-		time.Minute,
-		true,
-	)
+	get(aPath, &types.ImgParsSt{})
+	file := get(aPath, &types.ImgParsSt{})
+	require.NotNil(t, file.Meta)
+	require.Equal(t, "a.txt", file.Meta.OriginalName)
 
-	// Start tests
-	code := m.Run()
+	stats := cr.Cache.Stats()[core.CachePoolRaw]
+	require.Equal(t, &types.CacheStatsSt{Budget: 1000, Size: 500, Count: 1, Hits: 1, Misses: 1}, stats)
 
-	// cleanTestDir()
+	get(bPath, &types.ImgParsSt{})
+	get(aPath, &types.ImgParsSt{})
 
-	os.Exit(code)
-}
+	// b is the least recently used one
+	get(dPath, &types.ImgParsSt{})
 
-func TestCreate(t *testing.T) {
-	cleanTestDir()
+	stats = cr.Cache.Stats()[core.CachePoolRaw]
+	require.Equal(t, int64(800), stats.Size)
+	require.Equal(t, 2, stats.Count)
+	require.Equal(t, uint64(1), stats.Evictions)
 
-	_, err := app.core.Static.Create("asd/"+cns.ZipDirNamePrefix+"_asd", "a.txt", bytes.NewBuffer([]byte("test_data")), false, false)
-	require.NotNil(t, err)
-	require.Equal(t, errs.BadDirName, err)
+	get(aPath, &types.ImgParsSt{})
+	require.Equal(t, uint64(3), cr.Cache.Stats()[core.CachePoolRaw].Hits)
 
-	_, err = app.core.Static.Create(cns.ZipDirNamePrefix+"_asd/asd", "a.txt", bytes.NewBuffer([]byte("test_data")), false, false)
-	require.NotNil(t, err)
-	require.Equal(t, errs.BadDirName, err)
+	// too big to be cached
+	get(cPath, &types.ImgParsSt{})
+	get(cPath, &types.ImgParsSt{})
+	require.Equal(t, 2, cr.Cache.Stats()[core.CachePoolRaw].Count)
+
+	// derivatives are in their own pool
+	srcImgBuffer := new(bytes.Buffer)
 
-	fPath, err := app.core.Static.Create("photos", "data.txt", bytes.NewBuffer([]byte("test_data")), false, false)
+	err := imaging.Encode(srcImgBuffer, imaging.New(100, 100, color.White), imaging.PNG)
 	require.Nil(t, err)
 
-	fPathPrefix := "photos/" + time.Now().Format("2006/01/02") + "/"
+	imgPath, err := cr.Static.Create("photos", "a.png", srcImgBuffer, false, false, nil, nil)
+	require.Nil(t, err)
 
-	require.True(t, strings.HasPrefix(fPath, fPathPrefix))
-	require.False(t, strings.Contains(strings.TrimPrefix(fPath, fPathPrefix), "/"))
+	get(imgPath, &types.ImgParsSt{Width: 10})
+	get(imgPath, &types.ImgParsSt{Width: 10})
 
-	fName, _, fContent, err := app.core.Static.Get(fPath, &types.ImgParsSt{}, false)
+	stats = cr.Cache.Stats()[core.CachePoolImg]
+	require.Equal(t, 1, stats.Count)
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, 2, cr.Cache.Stats()[core.CachePoolRaw].Count)
+
+	// cached index.html of zip dir is not served for path without trailing slash
+	zipBuffer, err := createZipArchive([]fsItemSt{{p: "index.html", c: "html"}})
 	require.Nil(t, err)
-	require.NotNil(t, fContent)
-	require.Equal(t, "test_data", string(fContent))
-	require.NotEmpty(t, fName)
 
-	largeImg := imaging.New(imgMaxWidth+10, imgMaxHeight+10, color.RGBA{R: 0xaa, G: 0x00, B: 0x00, A: 0xff})
-	require.NotNil(t, largeImg)
+	zipPath, err := cr.Static.Create("site", "a.zip", zipBuffer, false, true, nil, nil)
+	require.Nil(t, err)
+	require.True(t, strings.HasSuffix(zipPath, "/"))
 
-	largeImgBuffer := new(bytes.Buffer)
+	file = get(zipPath, &types.ImgParsSt{})
+	require.Equal(t, "index.html", file.Name)
+	require.Equal(t, 3, cr.Cache.Stats()[core.CachePoolRaw].Count)
 
-	err = imaging.Encode(largeImgBuffer, largeImg, imaging.JPEG)
-	require.Nil(t, err)
+	_, err = cr.Static.Get(strings.TrimSuffix(zipPath, "/"), &types.ImgParsSt{}, false)
+	require.Equal(t, dopErrs.ObjectNotFound, err)
 
-	fPath, err = app.core.Static.Create("photos", "a.jpg", largeImgBuffer, true, false)
+	err = cr.Static.Remove(zipPath)
 	require.Nil(t, err)
+	require.Equal(t, 2, cr.Cache.Stats()[core.CachePoolRaw].Count)
 
-	_, _, fContent, err = app.core.Static.Get(fPath, &types.ImgParsSt{}, false)
+	err = cr.Static.Remove(aPath)
 	require.Nil(t, err)
-	require.NotNil(t, fContent)
+	require.Equal(t, 1, cr.Cache.Stats()[core.CachePoolRaw].Count)
 
-	img, err := imaging.Decode(bytes.NewBuffer(fContent))
+	err = cr.Static.Remove(imgPath)
 	require.Nil(t, err)
+	require.Equal(t, 0, cr.Cache.Stats()[core.CachePoolImg].Count)
+}
 
-	imgBounds := img.Bounds().Max
-	require.Equal(t, imgMaxWidth+10, imgBounds.X)
-	require.Equal(t, imgMaxHeight+10, imgBounds.X)
+func TestDerivative(t *testing.T) {
+	stg := storageMock.New()
 
-	largeImg = imaging.New(imgMaxWidth+10, imgMaxHeight+10, color.RGBA{R: 0xaa, G: 0x00, B: 0x00, A: 0xff})
-	require.NotNil(t, largeImg)
+	newCore := func(diskSize int64) *core.St {
+		cr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{}, core.CacheOptionsSt{
+			DiskSize: diskSize,
+		}, zipOpts, core.UploadOptionsSt{}, true)
+		cr.Start()
+		t.Cleanup(cr.StopAndWaitJobs)
+		return cr
+	}
 
-	largeImgBuffer = new(bytes.Buffer)
+	get := func(cr *core.St, fPath string, imgPars *types.ImgParsSt) []byte {
+		file, err := cr.Static.Get(fPath, imgPars, false)
+		require.Nil(t, err)
+		defer file.Close()
 
-	err = imaging.Encode(largeImgBuffer, largeImg, imaging.JPEG)
-	require.Nil(t, err)
+		data, err := io.ReadAll(file.Content)
+		require.Nil(t, err)
 
-	fPath, err = app.core.Static.Create("photos", "a.jpg", largeImgBuffer, false, false)
-	require.Nil(t, err)
+		return data
+	}
+
+	srcImg := func(c color.Color) *bytes.Buffer {
+		buf := new(bytes.Buffer)
+		err := imaging.Encode(buf, imaging.New(100, 100, c), imaging.PNG)
+		require.Nil(t, err)
+		return buf
+	}
+
+	cr := newCore(1 << 20)
 
-	_, _, fContent, err = app.core.Static.Get(fPath, &types.ImgParsSt{}, false)
+	imgPath, err := cr.Static.Create("photos", "a.png", srcImg(color.White), false, false, nil, nil)
 	require.Nil(t, err)
-	require.NotNil(t, fContent)
 
-	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	data := get(cr, imgPath, &types.ImgParsSt{Width: 10})
+
+	stats := cr.Cache.Stats()[core.CachePoolDisk]
+	require.Equal(t, &types.CacheStatsSt{Budget: 1 << 20, Size: int64(len(data)), Count: 1, Misses: 1}, stats)
+
+	items, err := stg.List(path.Join(cns.DerivativeDirName, imgPath))
 	require.Nil(t, err)
+	require.Len(t, items, 1)
 
-	imgBounds = img.Bounds().Max
-	require.Equal(t, imgMaxWidth, imgBounds.X)
-	require.Equal(t, imgMaxHeight, imgBounds.X)
+	// derivatives survive restart
+	cr = newCore(1 << 20)
+	require.Equal(t, 1, cr.Cache.Stats()[core.CachePoolDisk].Count)
+
+	require.Equal(t, data, get(cr, imgPath, &types.ImgParsSt{Width: 10}))
+	require.Equal(t, uint64(1), cr.Cache.Stats()[core.CachePoolDisk].Hits)
 
-	_, _, fContent, err = app.core.Static.Get(fPath, &types.ImgParsSt{Method: "fit", Width: imgMaxWidth - 10, Height: imgMaxHeight - 10}, false)
+	// derivative is outdated after modification of the source
+	time.Sleep(10 * time.Millisecond)
+
+	err = stg.Put(imgPath, srcImg(color.Black))
 	require.Nil(t, err)
-	require.NotNil(t, fContent)
 
-	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	img, err := imaging.Decode(bytes.NewReader(get(cr, imgPath, &types.ImgParsSt{Width: 10})))
 	require.Nil(t, err)
+	r, g, b, _ := img.At(5, 5).RGBA()
+	require.Equal(t, []uint32{0, 0, 0}, []uint32{r, g, b})
 
-	imgBounds = img.Bounds().Max
-	require.Equal(t, imgMaxWidth-10, imgBounds.X)
-	require.Equal(t, imgMaxHeight-10, imgBounds.X)
+	stats = cr.Cache.Stats()[core.CachePoolDisk]
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, 1, stats.Count)
 
-	_, _, fContent, err = app.core.Static.Get(fPath, &types.ImgParsSt{Method: "fit", Width: imgMaxWidth + 10, Height: imgMaxHeight + 10}, false)
+	// least recently used ones are removed over the quota
+	cr = newCore(int64(len(data)) * 3 / 2)
+
+	img2Path, err := cr.Static.Create("photos", "b.png", srcImg(color.White), false, false, nil, nil)
 	require.Nil(t, err)
-	require.NotNil(t, fContent)
 
-	img, err = imaging.Decode(bytes.NewBuffer(fContent))
+	get(cr, img2Path, &types.ImgParsSt{Width: 10})
+
+	stats = cr.Cache.Stats()[core.CachePoolDisk]
+	require.Equal(t, 1, stats.Count)
+	require.Equal(t, uint64(1), stats.Evictions)
+
+	_, err = stg.Stat(path.Join(cns.DerivativeDirName, imgPath))
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	// derivatives are removed with the source
+	err = cr.Static.Remove(img2Path)
 	require.Nil(t, err)
+	require.Equal(t, 0, cr.Cache.Stats()[core.CachePoolDisk].Count)
+
+	_, err = stg.Stat(path.Join(cns.DerivativeDirName, img2Path))
+	require.Equal(t, dopErrs.ObjectNotFound, err)
+
+	// derivatives made with other encoder defaults or watermark are not served
+	for _, imgOpts := range []core.ImgOptionsSt{
+		{},
+		{PngCompression: "no"},
+		{Quality: 50},
+		{WMark: imaging.New(5, 5, color.Black), WMarkPosition: "center", WMarkOpacity: 1},
+		{WMark: imaging.New(5, 5, color.White), WMarkPosition: "center", WMarkOpacity: 1},
+	} {
+		optsCr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, imgOpts, core.CacheOptionsSt{
+			DiskSize: 1 << 20,
+		}, zipOpts, core.UploadOptionsSt{}, true)
+		optsCr.Start()
+
+		get(optsCr, imgPath, &types.ImgParsSt{Width: 10})
+		require.Equal(t, uint64(0), optsCr.Cache.Stats()[core.CachePoolDisk].Hits)
+
+		optsCr.StopAndWaitJobs()
+	}
 
-	imgBounds = img.Bounds().Max
-	require.Equal(t, imgMaxWidth, imgBounds.X)
-	require.Equal(t, imgMaxHeight, imgBounds.X)
+	cr = newCore(1 << 20)
+
+	get(cr, imgPath, &types.ImgParsSt{Width: 10})
+	require.Equal(t, uint64(1), cr.Cache.Stats()[core.CachePoolDisk].Hits)
 }
 
-func TestCreateZip(t *testing.T) {
-	cleanTestDir()
+func TestImgCoalesce(t *testing.T) {
+	stg := &gatedStorage{
+		St:      storageMock.New(),
+		gate:    make(chan struct{}),
+		entered: make(chan struct{}),
+	}
 
-	zipContentIsSame := func(a, b []fsItemSt) {
-		require.Equal(t, len(a), len(b))
+	cr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{DecodeLimit: 1}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
 
-		for _, ai := range a {
-			found := false
+	srcImgBuffer := new(bytes.Buffer)
 
-			for _, bi := range b {
-				if bi.p == ai.p {
-					found = true
-					require.Equal(t, ai.c, bi.c)
-					break
-				}
-			}
+	err := imaging.Encode(srcImgBuffer, imaging.New(100, 100, color.White), imaging.PNG)
+	require.Nil(t, err)
 
-			require.True(t, found)
-		}
+	imgPath, err := cr.Static.Create("photos", "a.png", bytes.NewReader(srcImgBuffer.Bytes()), false, false, nil, nil)
+	require.Nil(t, err)
 
-		for _, bi := range b {
-			found := false
+	stg.gatePath = imgPath
 
-			for _, ai := range a {
-				if ai.p == bi.p {
-					found = true
-					require.Equal(t, bi.c, ai.c)
-					break
-				}
-			}
+	const reqCount = 10
 
-			require.True(t, found)
+	results := make(chan error, reqCount)
+
+	get := func() {
+		file, err := cr.Static.Get(imgPath, &types.ImgParsSt{Width: 10}, false)
+		if err == nil {
+			_, err = io.ReadAll(file.Content)
+			file.Close()
 		}
+		results <- err
 	}
 
-	srcZipFiles := []fsItemSt{
-		{p: "index.html", c: "some html content"},
-		{p: "abc/file.txt", c: "file content"},
-		{p: "abc/qwe/x.txt", c: "x content"},
-		{p: "todo.txt", c: "todo content"},
+	go get()
+
+	<-stg.entered
+
+	for i := 1; i < reqCount; i++ {
+		go get()
 	}
 
-	zipBuffer, err := createZipArchive(srcZipFiles)
+	// the only decode slot is taken
+	_, err = cr.Static.Get(imgPath, &types.ImgParsSt{Width: 20}, false)
+	require.Equal(t, errs.ImgBusy, err)
+
+	// uploads take the same slots
+	_, err = cr.Static.Create("photos", "b.png", bytes.NewReader(srcImgBuffer.Bytes()), false, false, nil, nil)
+	require.Equal(t, errs.ImgBusy, err)
+
+	time.Sleep(50 * time.Millisecond)
+	close(stg.gate)
+
+	for i := 0; i < reqCount; i++ {
+		require.Nil(t, <-results)
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&stg.reads))
+
+	// slot is released
+	_, err = cr.Static.Get(imgPath, &types.ImgParsSt{Width: 20}, false)
 	require.Nil(t, err)
 
-	_, err = app.core.Static.Create("zip/"+cns.ZipDirNamePrefix+"_asd", "a.zip", zipBuffer, false, true)
-	require.NotNil(t, err)
-	require.Equal(t, errs.BadDirName, err)
+	// and it is released on panic too
+	stg.panicPath = imgPath
 
-	_, err = app.core.Static.Create(cns.ZipDirNamePrefix+"_asd/zip", "a.zip", zipBuffer, false, true)
-	require.NotNil(t, err)
-	require.Equal(t, errs.BadDirName, err)
+	require.Panics(t, func() {
+		_, _ = cr.Static.Get(imgPath, &types.ImgParsSt{Width: 30}, false)
+	})
+
+	stg.panicPath = ""
 
-	fPath, err := app.core.Static.Create("zip", "a.zip", zipBuffer, false, true)
+	_, err = cr.Static.Get(imgPath, &types.ImgParsSt{Width: 30}, false)
 	require.Nil(t, err)
-	require.True(t, strings.HasSuffix(fPath, "/"))
+}
 
-	for _, zp := range srcZipFiles {
-		_, _, fContent, err := app.core.Static.Get(fPath+zp.p, &types.ImgParsSt{}, false)
-		require.Nil(t, err)
-		require.NotNil(t, fContent)
-		require.Equal(t, zp.c, string(fContent))
-	}
+func TestImgLimits(t *testing.T) {
+	stg := storageMock.New()
+
+	cr := core.New(app.lg, stg, imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{
+		MaxPixels:     100 * 100,
+		MaxFrames:     3,
+		MaxAnimPixels: 100 * 100 * 3 / 2,
+		MaxOutWidth:   50,
+		MaxOutHeight:  50,
+	}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
 
-	fName, _, fContent, err := app.core.Static.Get(fPath, &types.ImgParsSt{}, false)
+	srcImgBuffer := new(bytes.Buffer)
+
+	err := imaging.Encode(srcImgBuffer, imaging.New(100, 100, color.White), imaging.PNG)
 	require.Nil(t, err)
-	require.Equal(t, "index.html", fName)
-	require.NotNil(t, fContent)
-	require.Equal(t, "some html content", string(fContent))
 
-	fName, _, fContent, err = app.core.Static.Get(fPath, &types.ImgParsSt{}, true)
+	srcImg := srcImgBuffer.Bytes()
+
+	// header declares 60000x60000, pixels are never decoded
+	bomb := append([]byte{}, srcImg...)
+	binary.BigEndian.PutUint32(bomb[16:], 60000)
+	binary.BigEndian.PutUint32(bomb[20:], 60000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+
+	_, err = cr.Static.Create("photos", "bomb.png", bytes.NewReader(bomb), false, false, nil, nil)
+	require.Equal(t, errs.ImgTooLarge, err)
+
+	bombPath, err := cr.Static.Create("photos", "bomb.png", bytes.NewReader(bomb), true, false, nil, nil)
 	require.Nil(t, err)
-	require.True(t, strings.HasSuffix(fName, ".zip"))
-	require.NotNil(t, fContent)
 
-	resultZipFiles, err := extractZipArchive(fContent)
+	_, err = cr.Static.Get(bombPath, &types.ImgParsSt{Width: 10}, false)
+	require.Equal(t, errs.ImgTooLarge, err)
+
+	_, err = cr.Static.Info(bombPath)
+	require.Equal(t, errs.ImgTooLarge, err)
+
+	// broken images are not served as is
+	_, err = cr.Static.Create("photos", "bad.png", bytes.NewBufferString("not an image"), false, false, nil, nil)
+	require.Equal(t, errs.NotImg, err)
+
+	badPath, err := cr.Static.Create("photos", "bad.png", bytes.NewBufferString("not an image"), true, false, nil, nil)
 	require.Nil(t, err)
-	zipContentIsSame(srcZipFiles, resultZipFiles)
 
-	srcZipFiles = []fsItemSt{
-		{p: "root/index.html", c: "some html content"},
-		{p: "root/abc/file.txt", c: "file content"},
-		{p: "root/abc/qwe/x.txt", c: "x content"},
-		{p: "root/todo.txt", c: "todo content"},
-	}
+	_, err = cr.Static.Get(badPath, &types.ImgParsSt{Width: 10}, false)
+	require.Equal(t, errs.NotImg, err)
 
-	zipBuffer, err = createZipArchive(srcZipFiles)
+	imgPath, err := cr.Static.Create("photos", "a.png", bytes.NewReader(srcImg), false, false, nil, nil)
 	require.Nil(t, err)
 
-	fPath, err = app.core.Static.Create("zip", "a.zip", zipBuffer, false, true)
+	_, err = cr.Static.Get(imgPath, &types.ImgParsSt{Width: 51}, false)
+	require.Equal(t, errs.ImgTooLarge, err)
+
+	for _, pars := range []*types.ImgParsSt{{Width: 50}, {Width: 25, Dpr: 4}} {
+		file, err := cr.Static.Get(imgPath, pars, false)
+		require.Nil(t, err)
+
+		img, err := imaging.Decode(file.Content)
+		require.Nil(t, err)
+		require.Equal(t, 50, img.Bounds().Dx())
+
+		file.Close()
+	}
+
+	// rotation must not make image larger than the limits
+	_, err = cr.Static.Get(imgPath, &types.ImgParsSt{Rotate: 45}, false)
+	require.Equal(t, errs.ImgTooLarge, err)
+
+	file, err := cr.Static.Get(imgPath, &types.ImgParsSt{Rotate: 90}, false)
 	require.Nil(t, err)
-	require.True(t, strings.HasSuffix(fPath, "/"))
+	file.Close()
+
+	// frames of gif are counted, each of them takes the whole canvas
+	for _, frameSizes := range [][]int{{10, 10, 10, 10}, {100, 100}} {
+		anim := &gif.GIF{}
+		for _, size := range frameSizes {
+			anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, size, size), palette.Plan9))
+			anim.Delay = append(anim.Delay, 10)
+		}
 
-	for _, zp := range srcZipFiles {
-		_, _, fContent, err := app.core.Static.Get(fPath+strings.TrimLeft(zp.p, "root/"), &types.ImgParsSt{}, false)
+		animBuffer := new(bytes.Buffer)
+
+		err = gif.EncodeAll(animBuffer, anim)
 		require.Nil(t, err)
-		require.NotNil(t, fContent)
-		require.Equal(t, zp.c, string(fContent))
+
+		_, err = cr.Static.Create("photos", "a.gif", bytes.NewReader(animBuffer.Bytes()), false, false, nil, nil)
+		require.Equal(t, errs.ImgTooLarge, err)
+	}
+
+	anim := &gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 50, 50), palette.Plan9),
+			image.NewPaletted(image.Rect(0, 0, 50, 50), palette.Plan9),
+		},
+		Delay: []int{10, 10},
 	}
 
-	fName, _, fContent, err = app.core.Static.Get(fPath, &types.ImgParsSt{}, false)
+	animBuffer := new(bytes.Buffer)
+
+	err = gif.EncodeAll(animBuffer, anim)
 	require.Nil(t, err)
-	require.Equal(t, "index.html", fName)
-	require.NotNil(t, fContent)
-	require.Equal(t, "some html content", string(fContent))
+
+	_, err = cr.Static.Create("photos", "a.gif", bytes.NewReader(animBuffer.Bytes()), false, false, nil, nil)
+	require.Nil(t, err)
+
+	// tiny frames on a large screen
+	cr = core.New(app.lg, storageMock.New(), imgMaxWidth, imgMaxHeight, core.ImgOptionsSt{
+		MaxPixels:     2000 * 2000,
+		MaxAnimPixels: 2000 * 2000 * 10,
+	}, core.CacheOptionsSt{}, zipOpts, core.UploadOptionsSt{}, true)
+
+	anim = &gif.GIF{Config: image.Config{Width: 2000, Height: 2000, ColorModel: color.Palette(palette.Plan9)}}
+	for i := 0; i < 100; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9))
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	animBuffer = new(bytes.Buffer)
+
+	err = gif.EncodeAll(animBuffer, anim)
+	require.Nil(t, err)
+
+	animPath, err := cr.Static.Create("photos", "b.gif", bytes.NewReader(animBuffer.Bytes()), true, false, nil, nil)
+	require.Nil(t, err)
+
+	_, err = cr.Static.Get(animPath, &types.ImgParsSt{Grayscale: true}, false)
+	require.Equal(t, errs.ImgTooLarge, err)
 }
 
 // func TestClean(t *testing.T) {
@@ -424,6 +2167,36 @@ func TestCreateZip(t *testing.T) {
 // 	})
 // }
 
+func getStatic(reqPath string, imgPars *types.ImgParsSt, download bool) (string, time.Time, []byte, error) {
+	file, err := app.core.Static.Get(reqPath, imgPars, download)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	defer file.Close()
+
+	if file.Stream != nil {
+		buffer := new(bytes.Buffer)
+
+		err = file.Stream(buffer)
+		if err != nil {
+			return "", time.Time{}, nil, err
+		}
+
+		return file.Name, file.ModTime, buffer.Bytes(), nil
+	}
+
+	content, err := io.ReadAll(file.Content)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	if int64(len(content)) != file.Size {
+		return "", time.Time{}, nil, fmt.Errorf("size mismatch: %d != %d", len(content), file.Size)
+	}
+
+	return file.Name, file.ModTime, content, nil
+}
+
 func createZipArchive(items []fsItemSt) (*bytes.Buffer, error) {
 	result := new(bytes.Buffer)
 
@@ -628,3 +2401,105 @@ func compareStringSlices(t *testing.T, a, b []string) {
 		require.True(t, found, "String not found %q", bI)
 	}
 }
+
+// jpegWithExif encodes image to jpeg and inserts EXIF with orientation, camera, capture time and GPS
+func jpegWithExif(t *testing.T, img image.Image, orientation uint16) []byte {
+	type entrySt struct {
+		tag   uint16
+		typ   uint16
+		count uint32
+		value []byte
+	}
+
+	le := binary.LittleEndian
+
+	ascii := func(tag uint16, v string) entrySt {
+		return entrySt{tag, 2, uint32(len(v) + 1), append([]byte(v), 0)}
+	}
+	short := func(tag uint16, v uint16) entrySt {
+		return entrySt{tag, 3, 1, le.AppendUint16(nil, v)}
+	}
+	long := func(tag uint16, v uint32) entrySt {
+		return entrySt{tag, 4, 1, le.AppendUint32(nil, v)}
+	}
+	rationals := func(tag uint16, vs ...uint32) entrySt {
+		value := make([]byte, 0, len(vs)*4)
+		for _, v := range vs {
+			value = le.AppendUint32(value, v)
+		}
+		return entrySt{tag, 5, uint32(len(vs) / 2), value}
+	}
+
+	// ifd encodes directory placed at offset, values which do not fit into entry follow it
+	ifd := func(offset int, entries ...entrySt) []byte {
+		dataOffset := offset + 2 + len(entries)*12 + 4
+
+		head := le.AppendUint16(nil, uint16(len(entries)))
+		data := make([]byte, 0)
+
+		for _, e := range entries {
+			head = le.AppendUint16(head, e.tag)
+			head = le.AppendUint16(head, e.typ)
+			head = le.AppendUint32(head, e.count)
+
+			if len(e.value) <= 4 {
+				head = append(head, e.value...)
+				head = append(head, make([]byte, 4-len(e.value))...)
+			} else {
+				head = le.AppendUint32(head, uint32(dataOffset+len(data)))
+				data = append(data, e.value...)
+				if len(data)%2 == 1 {
+					data = append(data, 0)
+				}
+			}
+		}
+
+		head = le.AppendUint32(head, 0)
+
+		return append(head, data...)
+	}
+
+	ifd0 := func(exifOffset, gpsOffset uint32) []byte {
+		return ifd(
+			8,
+			ascii(0x010f, "TestMake"),
+			ascii(0x0110, "TestModel"),
+			short(0x0112, orientation),
+			long(0x8769, exifOffset),
+			long(0x8825, gpsOffset),
+		)
+	}
+
+	exifOffset := 8 + len(ifd0(0, 0))
+	exifIfd := ifd(exifOffset, ascii(0x9003, "2020:01:02 03:04:05"))
+	gpsOffset := exifOffset + len(exifIfd)
+	gpsIfd := ifd(
+		gpsOffset,
+		ascii(0x0001, "N"),
+		rationals(0x0002, 43, 1, 15, 1, 0, 1),
+		ascii(0x0003, "E"),
+		rationals(0x0004, 76, 1, 54, 1, 0, 1),
+	)
+
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = append(tiff, ifd0(uint32(exifOffset), uint32(gpsOffset))...)
+	tiff = append(tiff, exifIfd...)
+	tiff = append(tiff, gpsIfd...)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	buffer := new(bytes.Buffer)
+
+	err := jpeg.Encode(buffer, img, &jpeg.Options{Quality: 95})
+	require.Nil(t, err)
+
+	content := buffer.Bytes()
+
+	result := append([]byte{}, content[:2]...) // SOI
+	result = append(result, 0xff, 0xe1)
+	result = binary.BigEndian.AppendUint16(result, uint16(len(app1)+2))
+	result = append(result, app1...)
+	result = append(result, content[2:]...)
+
+	return result
+}
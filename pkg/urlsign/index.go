@@ -0,0 +1,97 @@
+// Package urlsign signs urls of transformed and downloaded files of kazan.
+//
+// Signature is HMAC-SHA256 over file path and all query parameters (including expiry),
+// computed with the secret shared with the service (URL_SIGN_SECRET).
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ParamSignature = "sig"
+	ParamExpires   = "exp"
+)
+
+var (
+	ErrNoSignature  = errors.New("no signature")
+	ErrBadSignature = errors.New("bad signature")
+	ErrExpired      = errors.New("signature expired")
+)
+
+// Sign returns copy of query with expiry (if it is not zero) and signature added
+func Sign(secret string, filePath string, query url.Values, expires time.Time) url.Values {
+	result := url.Values{}
+	for k, v := range query {
+		if k != ParamSignature {
+			result[k] = append([]string{}, v...)
+		}
+	}
+
+	result.Del(ParamExpires)
+	if !expires.IsZero() {
+		result.Set(ParamExpires, strconv.FormatInt(expires.Unix(), 10))
+	}
+
+	result.Set(ParamSignature, signature(secret, filePath, result))
+
+	return result
+}
+
+// Url returns signed url of the file, baseUrl is address of the service, like https://files.example.com
+func Url(secret string, baseUrl string, filePath string, query url.Values, expires time.Time) string {
+	u := &url.URL{Path: "/static/" + cleanPath(filePath)}
+
+	return strings.TrimRight(baseUrl, "/") + u.EscapedPath() + "?" + Sign(secret, filePath, query, expires).Encode()
+}
+
+// Verify checks signature and expiry of the query
+func Verify(secret string, filePath string, query url.Values, now time.Time) error {
+	sig := query.Get(ParamSignature)
+	if sig == "" {
+		return ErrNoSignature
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, filePath, query))) {
+		return ErrBadSignature
+	}
+
+	if v := query.Get(ParamExpires); v != "" {
+		expires, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return ErrBadSignature
+		}
+
+		if now.Unix() > expires {
+			return ErrExpired
+		}
+	}
+
+	return nil
+}
+
+func signature(secret string, filePath string, query url.Values) string {
+	values := url.Values{}
+	for k, v := range query {
+		if k != ParamSignature {
+			values[k] = v
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(cleanPath(filePath) + "?" + values.Encode()))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
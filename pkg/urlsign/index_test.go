@@ -0,0 +1,51 @@
+package urlsign
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	const secret = "secret"
+
+	now := time.Now()
+
+	query := Sign(secret, "photos/a.jpg", url.Values{"w": {"100"}, "m": {"fit"}}, time.Time{})
+	require.NotEmpty(t, query.Get(ParamSignature))
+	require.Empty(t, query.Get(ParamExpires))
+	require.Nil(t, Verify(secret, "photos/a.jpg", query, now))
+
+	// path is normalized
+	require.Nil(t, Verify(secret, "/photos//a.jpg", query, now))
+
+	require.Equal(t, ErrBadSignature, Verify("other", "photos/a.jpg", query, now))
+	require.Equal(t, ErrBadSignature, Verify(secret, "photos/b.jpg", query, now))
+
+	tampered := url.Values{}
+	for k, v := range query {
+		tampered[k] = v
+	}
+	tampered.Set("w", "4000")
+	require.Equal(t, ErrBadSignature, Verify(secret, "photos/a.jpg", tampered, now))
+
+	tampered.Del("w")
+	require.Equal(t, ErrBadSignature, Verify(secret, "photos/a.jpg", tampered, now))
+
+	require.Equal(t, ErrNoSignature, Verify(secret, "photos/a.jpg", url.Values{"w": {"100"}}, now))
+
+	query = Sign(secret, "photos/a.jpg", url.Values{"download": {"x"}}, now.Add(time.Minute))
+	require.NotEmpty(t, query.Get(ParamExpires))
+	require.Nil(t, Verify(secret, "photos/a.jpg", query, now))
+	require.Equal(t, ErrExpired, Verify(secret, "photos/a.jpg", query, now.Add(2*time.Minute)))
+
+	query.Set(ParamExpires, query.Get(ParamExpires)+"0")
+	require.Equal(t, ErrBadSignature, Verify(secret, "photos/a.jpg", query, now))
+
+	u, err := url.Parse(Url(secret, "https://files.example.com/", "/photos/my dir/a.jpg", url.Values{"w": {"100"}}, time.Time{}))
+	require.Nil(t, err)
+	require.Equal(t, "/static/photos/my dir/a.jpg", u.Path)
+	require.Nil(t, Verify(secret, "photos/my dir/a.jpg", u.Query(), now))
+}